@@ -1,34 +1,126 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	djt "github.com/mimiro-io/datahub-job-testing"
+	"github.com/mimiro-io/datahub-job-testing/reports"
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	"go.uber.org/zap/zapcore"
 	"os"
+	"strings"
 )
 
+// outputFlag collects repeated -output format=path flags
+type outputFlag map[string]string
+
+func (o outputFlag) String() string {
+	var outputs []string
+	for format, path := range o {
+		outputs = append(outputs, format+"="+path)
+	}
+	return strings.Join(outputs, ",")
+}
+
+func (o outputFlag) Set(value string) error {
+	format, path, found := strings.Cut(value, "=")
+	if !found || path == "" {
+		return fmt.Errorf("expected format=path, got '%s'", value)
+	}
+	switch format {
+	case "junit", "json", "tap":
+		o[format] = path
+	default:
+		return fmt.Errorf("unsupported output format '%s'", format)
+	}
+	return nil
+}
+
 func main() {
 
 	usage := `
 Usage:
-  djt path/to/manifest.json [test_id]
+  djt [options] path/to/manifest.json [test_id]
+
+Options:
+  -parallel int           number of tests to run concurrently (default 1)
+  -reuse                  run the tests of each worker on one datahub, reset between tests
+  -verbose                list ignored diffs and full missing and extra entities
+  -keep-on-failure        stop at the first failing test and keep its datahub running until interrupted
+  -log-level level        minimum level of datahub logs listed for failing tests:
+                          debug, info, warn or error (default info)
+  -update                 rewrite the expected output files from the job output instead of comparing
+  -output format=path     write a report of the run to path, can be repeated.
+                          Supported formats: junit, json, tap. Use - as path for stdout
 
 Help:
   https://github.com/mimiro-io/datahub-job-testing
 `
 
-	args := os.Args[1:]
+	outputs := outputFlag{}
+	flags := flag.NewFlagSet("djt", flag.ExitOnError)
+	flags.Usage = func() { fmt.Print(usage) }
+	parallel := flags.Int("parallel", 1, "number of tests to run concurrently")
+	reuse := flags.Bool("reuse", false, "run the tests of each worker on one datahub, reset between tests")
+	keep := flags.Bool("keep-on-failure", false, "stop at the first failing test and keep its datahub running")
+	update := flags.Bool("update", false, "rewrite the expected output files from the job output")
+	verbose := flags.Bool("verbose", false, "list ignored diffs and full missing and extra entities")
+	logLevel := zapcore.InfoLevel
+	flags.Var(&logLevel, "log-level", "minimum level of datahub logs listed for failing tests")
+	flags.Var(outputs, "output", "write a report of the run to path")
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
 	if len(args) == 0 {
 		fmt.Print(usage)
 		os.Exit(1)
 	}
 
-	tr := djt.NewTestRunner(args[0])
+	tr := djt.NewTestRunner(args[0]).
+		WithParallelism(*parallel).
+		WithReuseDatahub(*reuse).
+		WithKeepOnFailure(*keep).
+		WithLogLevel(logLevel).
+		WithUpdateSnapshots(*update).
+		WithVerbose(*verbose)
+
+	var files []*os.File
+	for format, path := range outputs {
+		w := os.Stdout
+		if path != "-" {
+			file, err := os.Create(path)
+			if err != nil {
+				fmt.Printf("failed to create %s report '%s': %s\n", format, path, err)
+				os.Exit(1)
+			}
+			files = append(files, file)
+			w = file
+		}
+		switch format {
+		case "junit":
+			tr.AddReporter(reports.NewJUnitReporter(w))
+		case "json":
+			tr.AddReporter(reports.NewJSONReporter(w))
+		case "tap":
+			tr.AddReporter(reports.NewTAPReporter(w))
+		}
+	}
 
-	var singleTest string
+	var result *testing.SuiteResult
 	if len(args) > 1 {
-		singleTest = args[1]
-		tr.RunSingleTest(singleTest)
+		result = tr.RunSingleTest(args[1])
 	} else {
-		tr.RunAllTests()
+		result = tr.RunAllTests()
+	}
+	reportFailed := result.ReportError != nil
+	for _, file := range files {
+		if err := file.Close(); err != nil {
+			fmt.Printf("failed to write report '%s': %s\n", file.Name(), err)
+			reportFailed = true
+		}
+	}
+
+	if !result.Success() || reportFailed {
+		os.Exit(1)
 	}
 }
@@ -1,13 +1,39 @@
 package jobs
 
 import (
-	"fmt"
 	"github.com/mimiro-io/datahub-client-sdk-go"
 	"time"
 )
 
+// JobError is the error of a finished job run, as reported in the job history of the datahub
+type JobError struct {
+	JobId     string
+	LastError string
+}
+
+func (e *JobError) Error() string {
+	return e.LastError
+}
+
+// RunAndWait runs the job as a full sync and waits for it to finish
 func RunAndWait(client *datahub.Client, jobId string) error {
-	err := client.RunJobAsFullSync(jobId)
+	return runAndWait(client, jobId, client.RunJobAsFullSync)
+}
+
+// RunIncrementalAndWait runs the job incrementally from its continuation token and waits for it to finish
+func RunIncrementalAndWait(client *datahub.Client, jobId string) error {
+	return runAndWait(client, jobId, client.RunJobAsIncremental)
+}
+
+// runAndWait starts the job with the run function and waits until the job history has a newer entry for the job.
+// The datahub starts job runs asynchronously, so the job status alone can not tell a finished run from one that
+// has not started yet.
+func runAndWait(client *datahub.Client, jobId string, run func(id string) error) error {
+	previous, err := lastJobResult(client, jobId)
+	if err != nil {
+		return err
+	}
+	err = run(jobId)
 	if err != nil {
 		return err
 	}
@@ -16,23 +42,32 @@ func RunAndWait(client *datahub.Client, jobId string) error {
 		if err != nil {
 			return err
 		}
-
 		if status == nil {
-			break
+			current, err := lastJobResult(client, jobId)
+			if err != nil {
+				return err
+			}
+			if current != nil && (previous == nil || current.End.After(previous.End)) {
+				if current.LastError != "" {
+					return &JobError{JobId: jobId, LastError: current.LastError}
+				}
+				return nil
+			}
 		}
-
-		time.Sleep(1 * time.Second)
+		time.Sleep(200 * time.Millisecond)
 	}
-	result, err := client.GetJobsHistory()
+}
+
+// lastJobResult returns the history entry of the last finished run of the job, or nil if it never ran
+func lastJobResult(client *datahub.Client, jobId string) (*datahub.JobResult, error) {
+	history, err := client.GetJobsHistory()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, job := range result {
+	for _, job := range history {
 		if job.ID == jobId {
-			if job.LastError != "" {
-				return fmt.Errorf(job.LastError)
-			}
+			return job, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mimiro-io/datahub-client-sdk-go"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDatahub serves the job endpoints used by runAndWait. A run is only recorded in the history after the
+// status has been polled a few times, like a run that the datahub starts asynchronously.
+type fakeDatahub struct {
+	mu        sync.Mutex
+	history   []*datahub.JobResult
+	jobType   string
+	polls     int
+	lastError string
+}
+
+func (f *fakeDatahub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/job/job1/run":
+		f.jobType = r.URL.Query().Get("jobType")
+		f.polls = 3
+		w.Write([]byte("{}"))
+	case r.URL.Path == "/job/job1/status":
+		var statuses []*datahub.JobStatus
+		if f.polls > 0 {
+			f.polls--
+			if f.polls < 2 {
+				statuses = append(statuses, &datahub.JobStatus{JobId: "job1"})
+			}
+			if f.polls == 0 {
+				f.history = []*datahub.JobResult{{ID: "job1", End: time.Now(), LastError: f.lastError}}
+			}
+		}
+		json.NewEncoder(w).Encode(statuses)
+	case r.URL.Path == "/jobs/_/history":
+		json.NewEncoder(w).Encode(f.history)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRunAndWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		run       func(client *datahub.Client, jobId string) error
+		previous  bool
+		lastError string
+		jobType   string
+	}{
+		{"full sync", RunAndWait, false, "", "fullsync"},
+		{"full sync after a previous run", RunAndWait, true, "", "fullsync"},
+		{"incremental", RunIncrementalAndWait, true, "", "incremental"},
+		{"job error", RunAndWait, true, "transform failed", "fullsync"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeDatahub{lastError: test.lastError}
+			if test.previous {
+				fake.history = []*datahub.JobResult{{ID: "job1", End: time.Now().Add(-time.Minute)}}
+			}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			client, _ := datahub.NewClient(server.URL)
+
+			err := test.run(client, "job1")
+			var jobError *JobError
+			if test.lastError != "" {
+				if !errors.As(err, &jobError) || jobError.LastError != test.lastError || jobError.JobId != "job1" {
+					t.Fatalf("expected job error %q, got %v", test.lastError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected the run to succeed, got %v", err)
+			}
+			if fake.polls != 0 {
+				t.Errorf("returned before the run was recorded in the history")
+			}
+			if fake.jobType != test.jobType {
+				t.Errorf("expected a %s run, got %s", test.jobType, fake.jobType)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package reports
+
+import (
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	"log"
+	"os"
+	"strings"
+)
+
+// ConsoleReporter logs test progress, diffs and a summary to the standard logger.
+// Diffs are grouped by entity, with a unified json diff of each entity with diffs.
+type ConsoleReporter struct {
+	Color bool // color unified diffs, enabled by default when stderr is a terminal and NO_COLOR is not set
+}
+
+func NewConsoleReporter() *ConsoleReporter {
+	return &ConsoleReporter{Color: colorSupported()}
+}
+
+// WithColor enables or disables colored diffs
+func (c *ConsoleReporter) WithColor(color bool) *ConsoleReporter {
+	c.Color = color
+	return c
+}
+
+func (c *ConsoleReporter) SuiteStarted(tests []*testing.Test) {
+	log.Printf("Running %d tests", len(tests))
+}
+
+func (c *ConsoleReporter) TestStarted(test *testing.Test) {
+	log.Printf("Running test %s", test.Id)
+}
+
+func (c *ConsoleReporter) TestFinished(result *testing.TestResult) {
+	for _, path := range result.UpdatedSnapshots {
+		log.Printf("Updated expected output %s for test %s", path, result.Id)
+	}
+	if len(result.TransformLogs) > 0 {
+		log.Printf("Transform logs for test %s", result.Id)
+		for _, entry := range result.TransformLogs {
+			log.Printf("%s -   %s %s", result.Id, strings.ToUpper(entry.Level), entry.Message)
+		}
+	}
+	switch result.Status {
+	case testing.StatusError:
+		log.Printf("Test %s failed in phase '%s': %s", result.Id, result.Phase, result.Error)
+	case testing.StatusFailed:
+		log.Printf("Listing diffs for test %s", result.Id)
+		c.logDiffs(result)
+	case testing.StatusSkipped:
+		if result.Error != "" {
+			log.Printf("Test %s skipped: %s", result.Id, result.Error)
+		} else {
+			log.Printf("Test %s skipped", result.Id)
+		}
+	case testing.StatusPassed:
+		if len(result.Diffs) > 0 {
+			log.Printf("Listing ignored diffs for test %s", result.Id)
+			c.logDiffs(result)
+		}
+	}
+	if (result.Status == testing.StatusFailed || result.Status == testing.StatusError) && len(result.Logs) > 0 {
+		log.Printf("Datahub logs for test %s", result.Id)
+		for _, entry := range result.Logs {
+			log.Printf("%s -   %s", result.Id, entry)
+		}
+	}
+}
+
+func (c *ConsoleReporter) SuiteFinished(suite *testing.SuiteResult) error {
+	if updated := suite.UpdatedSnapshots(); len(updated) > 0 {
+		log.Printf("Updated %d expected output file(s): %s", len(updated), strings.Join(updated, ", "))
+	}
+	if suite.Success() {
+		log.Printf("All %d tests ran successfully!", len(suite.Tests))
+	} else {
+		log.Printf("Finished running %d tests. One or more tests failed", len(suite.Tests))
+	}
+	return nil
+}
+
+// logDiffs logs the diffs of the result grouped by entity, followed by the unified diff of the entity if available
+func (c *ConsoleReporter) logDiffs(result *testing.TestResult) {
+	comparisons := map[string]*testing.EntityComparison{}
+	for _, comparison := range result.Entities {
+		comparisons[comparison.Assertion+" "+comparison.Id] = comparison
+	}
+
+	var groups []string
+	grouped := map[string][]testing.Diff{}
+	for _, diff := range result.Diffs {
+		group := diff.Assertion + " " + diff.EntityId
+		if _, found := grouped[group]; !found {
+			groups = append(groups, group)
+		}
+		grouped[group] = append(grouped[group], diff)
+	}
+
+	for _, group := range groups {
+		diffs := grouped[group]
+		entity := result.Namespaces.Prefixed(diffs[0].EntityId)
+		if entity == "" {
+			// diffs not about entities, like expected logs
+			log.Printf("%s - Diffs in %s", result.Id, diffs[0].Assertion)
+		} else if diffs[0].Assertion != "" {
+			log.Printf("%s - Entity %s in %s", result.Id, entity, diffs[0].Assertion)
+		} else {
+			log.Printf("%s - Entity %s", result.Id, entity)
+		}
+		for _, diff := range diffs {
+			log.Printf("%s -   %s", result.Id, diff.Render(result.Namespaces))
+		}
+		if comparison, found := comparisons[group]; found {
+			log.Printf("%s - Entity diff:\n%s", result.Id, UnifiedEntityDiff(comparison, result.Namespaces, c.Color))
+		}
+	}
+}
+
+// colorSupported returns true if stderr, where the standard logger writes, is a terminal and NO_COLOR is not set
+func colorSupported() bool {
+	if _, found := os.LookupEnv("NO_COLOR"); found {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
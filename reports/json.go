@@ -0,0 +1,28 @@
+package reports
+
+import (
+	"encoding/json"
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	"io"
+)
+
+// JSONReporter writes the suite result as a json document when the suite is finished
+type JSONReporter struct {
+	w io.Writer
+}
+
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{w: w}
+}
+
+func (j *JSONReporter) SuiteStarted(tests []*testing.Test) {}
+
+func (j *JSONReporter) TestStarted(test *testing.Test) {}
+
+func (j *JSONReporter) TestFinished(result *testing.TestResult) {}
+
+func (j *JSONReporter) SuiteFinished(suite *testing.SuiteResult) error {
+	encoder := json.NewEncoder(j.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(suite)
+}
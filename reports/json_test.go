@@ -0,0 +1,55 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	gotesting "testing"
+)
+
+func TestJSONReporter(t *gotesting.T) {
+	var out bytes.Buffer
+	if err := NewJSONReporter(&out).SuiteFinished(exampleSuite()); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Tests    []map[string]any `json:"tests"`
+		Duration float64          `json:"duration"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Duration != 3e9 || len(doc.Tests) != 4 {
+		t.Fatalf("expected 4 tests and the duration in nanoseconds, got %s", out.String())
+	}
+
+	tests := []struct {
+		id   string
+		keys []string
+	}{
+		{"passed", []string{"duration", "expectedEntities", "id", "name", "resultEntities", "status"}},
+		{"failed", []string{"description", "diffs", "duration", "expectedEntities", "id", "name", "phase", "resultEntities", "status"}},
+		{"errored", []string{"duration", "error", "expectedEntities", "id", "jobError", "name", "phase", "resultEntities", "status"}},
+		{"skipped", []string{"duration", "error", "expectedEntities", "id", "name", "resultEntities", "status"}},
+	}
+	for i, test := range tests {
+		t.Run(test.id, func(t *gotesting.T) {
+			result := doc.Tests[i]
+			var keys []string
+			for key := range result {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if result["id"] != test.id || !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("expected %s with keys %v, got %s with %v", test.id, test.keys, result["id"], keys)
+			}
+		})
+	}
+
+	diff := doc.Tests[1]["diffs"].([]any)[0].(map[string]any)
+	if diff["type"] != "diff" || diff["entityId"] != "http://data.mimiro.io/test/1" || diff["expectedValue"] != "one" ||
+		diff["resultValue"] != "uno" || diff["valueType"] != "prop" {
+		t.Errorf("unexpected diff %v", diff)
+	}
+}
@@ -0,0 +1,169 @@
+package reports
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	"io"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name       string           `xml:"name,attr"`
+	ClassName  string           `xml:"classname,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Failure    *junitMessage    `xml:"failure,omitempty"`
+	Error      *junitMessage    `xml:"error,omitempty"`
+	Skipped    *junitMessage    `xml:"skipped,omitempty"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+}
+
+type junitProperties struct {
+	Properties []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the suite result as JUnit XML with one testcase per manifest test
+func WriteJUnit(suite *testing.SuiteResult, w io.Writer) error {
+	ts := junitTestSuite{
+		Name:      "datahub-job-testing",
+		Tests:     len(suite.Tests),
+		Failures:  suite.Count(testing.StatusFailed),
+		Errors:    suite.Count(testing.StatusError),
+		Skipped:   suite.Count(testing.StatusSkipped),
+		Time:      seconds(suite.Duration),
+		Timestamp: time.Now().Add(-suite.Duration).Format(time.RFC3339),
+	}
+	for _, result := range suite.Tests {
+		ts.TestCases = append(ts.TestCases, toJUnitTestCase(result))
+	}
+	doc := junitTestSuites{
+		Tests:    ts.Tests,
+		Failures: ts.Failures,
+		Errors:   ts.Errors,
+		Skipped:  ts.Skipped,
+		Time:     ts.Time,
+		Suites:   []junitTestSuite{ts},
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// JUnitReporter writes the suite result as JUnit XML when the suite is finished
+type JUnitReporter struct {
+	w io.Writer
+}
+
+func NewJUnitReporter(w io.Writer) *JUnitReporter {
+	return &JUnitReporter{w: w}
+}
+
+func (j *JUnitReporter) SuiteStarted(tests []*testing.Test) {}
+
+func (j *JUnitReporter) TestStarted(test *testing.Test) {}
+
+func (j *JUnitReporter) TestFinished(result *testing.TestResult) {}
+
+func (j *JUnitReporter) SuiteFinished(suite *testing.SuiteResult) error {
+	return WriteJUnit(suite, j.w)
+}
+
+func toJUnitTestCase(result *testing.TestResult) junitTestCase {
+	name := result.Name
+	if name == "" {
+		name = result.Id
+	}
+	tc := junitTestCase{
+		Name:      name,
+		ClassName: result.Id,
+		Time:      seconds(result.Duration),
+		SystemOut: result.JobError,
+	}
+	if result.Description != "" {
+		tc.Properties = &junitProperties{Properties: []junitProperty{{Name: "description", Value: result.Description}}}
+	}
+
+	switch result.Status {
+	case testing.StatusFailed:
+		var lines []string
+		count := 0
+		for _, diff := range result.Diffs {
+			lines = append(lines, renderDiff(diff, result.Namespaces))
+			if !diff.Ignored() {
+				count++
+			}
+		}
+		tc.Failure = &junitMessage{
+			Message: fmt.Sprintf("%d diff(s) between expected and result entities", count),
+			Type:    string(result.Phase),
+			Text:    strings.Join(lines, "\n"),
+		}
+	case testing.StatusError:
+		tc.Error = &junitMessage{
+			Message: fmt.Sprintf("failed in phase '%s'", result.Phase),
+			Type:    string(result.Phase),
+			Text:    result.Error,
+		}
+	case testing.StatusSkipped:
+		tc.Skipped = &junitMessage{Message: result.Error}
+	}
+	lines := []string{tc.SystemOut}
+	for _, entry := range result.TransformLogs {
+		lines = append(lines, entry.String())
+	}
+	if !result.Passed() {
+		for _, entry := range result.Logs {
+			lines = append(lines, entry.String())
+		}
+	}
+	tc.SystemOut = strings.TrimPrefix(strings.Join(lines, "\n"), "\n")
+	return tc
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,110 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strings"
+	gotesting "testing"
+	"time"
+
+	"github.com/mimiro-io/datahub-job-testing/testing"
+)
+
+// exampleSuite returns a suite with a passed, a failed, an errored and a skipped test
+func exampleSuite() *testing.SuiteResult {
+	passed := &testing.TestResult{Id: "passed", Status: testing.StatusPassed, Duration: 1500 * time.Millisecond}
+	failed := &testing.TestResult{Id: "failed", Name: "Animals", Description: "maps animals", Status: testing.StatusFailed,
+		Phase: testing.PhaseCompare, Diffs: []testing.Diff{
+			{Type: "diff", EntityId: "http://data.mimiro.io/test/1", Key: "http://data.mimiro.io/test/name",
+				ExpectedValue: "one", ResultValue: "uno", ValueType: "prop"},
+			{Type: "missing", EntityId: "http://data.mimiro.io/test/2", Key: "http://data.mimiro.io/test/2",
+				ExpectedValue: "N/A", ResultValue: "N/A", ValueType: "entity"},
+		}}
+	errored := testing.NewTestResult(&testing.Test{Id: "errored"})
+	errored.SetError(testing.PhaseRun, errors.New("job failed"))
+	errored.JobError = "ReferenceError: x is not defined"
+	skipped := &testing.TestResult{Id: "skipped", Status: testing.StatusSkipped, Error: "stopped at failed"}
+	return &testing.SuiteResult{Tests: []*testing.TestResult{passed, failed, errored, skipped}, Duration: 3 * time.Second}
+}
+
+func TestWriteJUnit(t *gotesting.T) {
+	var out bytes.Buffer
+	if err := WriteJUnit(exampleSuite(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Errorf("expected an xml header, got %q", out.String()[:20])
+	}
+	var doc junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Tests != 4 || doc.Failures != 1 || doc.Errors != 1 || doc.Skipped != 1 || doc.Time != "3.000" || len(doc.Suites) != 1 {
+		t.Fatalf("unexpected totals %+v", doc)
+	}
+	cases := doc.Suites[0].TestCases
+	if len(cases) != 4 {
+		t.Fatalf("expected 4 test cases, got %d", len(cases))
+	}
+	passed, failed, errored, skipped := cases[0], cases[1], cases[2], cases[3]
+
+	if passed.Name != "passed" || passed.ClassName != "passed" || passed.Time != "1.500" ||
+		passed.Failure != nil || passed.Error != nil || passed.Skipped != nil || passed.SystemOut != "" {
+		t.Errorf("expected a plain test case named by id, got %+v", passed)
+	}
+
+	if failed.Name != "Animals" || failed.ClassName != "failed" {
+		t.Errorf("expected the name and the id as class name, got %s %s", failed.Name, failed.ClassName)
+	}
+	if failed.Properties == nil || failed.Properties.Properties[0] != (junitProperty{Name: "description", Value: "maps animals"}) {
+		t.Errorf("expected the description as property, got %+v", failed.Properties)
+	}
+	if failed.Failure == nil || failed.Failure.Message != "2 diff(s) between expected and result entities" ||
+		failed.Failure.Type != "compare" || len(strings.Split(failed.Failure.Text, "\n")) != 2 ||
+		!strings.Contains(failed.Failure.Text, "uno") {
+		t.Errorf("expected a failure with one line per diff, got %+v", failed.Failure)
+	}
+
+	if errored.Error == nil || errored.Error.Message != "failed in phase 'run'" || errored.Error.Type != "run" ||
+		errored.Error.Text != "job failed" || errored.Failure != nil {
+		t.Errorf("expected an error in the run phase, got %+v", errored.Error)
+	}
+	if errored.SystemOut != "ReferenceError: x is not defined" {
+		t.Errorf("expected the job error in system-out, got %q", errored.SystemOut)
+	}
+
+	if skipped.Skipped == nil || skipped.Skipped.Message != "stopped at failed" {
+		t.Errorf("expected a skipped element with the reason, got %+v", skipped.Skipped)
+	}
+}
+
+func TestJUnitSystemOut(t *gotesting.T) {
+	logged := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := testing.LogEntry{Time: logged, Level: "info", Logger: "scheduler", Message: "job started"}
+	transformed := testing.LogEntry{Time: logged, Level: "warn", Logger: "scheduler.transform", Message: "transformed 1"}
+	tests := []struct {
+		name      string
+		result    *testing.TestResult
+		systemOut string
+	}{
+		{"logs of a failed test", &testing.TestResult{Id: "failed", Status: testing.StatusFailed, Logs: []testing.LogEntry{entry}},
+			"12:00:00.000 INFO  scheduler job started"},
+		{"logs of a passed test", &testing.TestResult{Id: "passed", Status: testing.StatusPassed, Logs: []testing.LogEntry{entry}}, ""},
+		{"job error before logs", &testing.TestResult{Id: "errored", Status: testing.StatusError, JobError: "boom", Logs: []testing.LogEntry{entry}},
+			"boom\n12:00:00.000 INFO  scheduler job started"},
+		{"transform logs of a passed test", &testing.TestResult{Id: "passed", Status: testing.StatusPassed,
+			TransformLogs: []testing.LogEntry{transformed}, Logs: []testing.LogEntry{entry}},
+			"12:00:00.000 WARN  scheduler.transform transformed 1"},
+		{"transform logs before other logs", &testing.TestResult{Id: "failed", Status: testing.StatusFailed,
+			TransformLogs: []testing.LogEntry{transformed}, Logs: []testing.LogEntry{entry}},
+			"12:00:00.000 WARN  scheduler.transform transformed 1\n12:00:00.000 INFO  scheduler job started"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			if systemOut := toJUnitTestCase(test.result).SystemOut; systemOut != test.systemOut {
+				t.Errorf("expected system-out %q, got %q", test.systemOut, systemOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package reports
+
+import (
+	"github.com/mimiro-io/datahub-job-testing/testing"
+)
+
+// Reporter receives events from the test runner. Events are delivered one at a time,
+// but TestStarted and TestFinished arrive in completion order when tests run in parallel.
+type Reporter interface {
+	SuiteStarted(tests []*testing.Test)
+	TestStarted(test *testing.Test)
+	TestFinished(result *testing.TestResult)
+	SuiteFinished(suite *testing.SuiteResult) error
+}
+
+// renderDiff renders the diff on a single line, prefixed with its assertion in tests with several expected outputs
+func renderDiff(diff testing.Diff, namespaces testing.Namespaces) string {
+	if diff.Assertion != "" {
+		return diff.Assertion + ": " + diff.Render(namespaces)
+	}
+	return diff.Render(namespaces)
+}
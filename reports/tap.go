@@ -0,0 +1,71 @@
+package reports
+
+import (
+	"fmt"
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	"io"
+	"strings"
+)
+
+// TAPReporter writes the suite result in TAP version 13 format when the suite is finished,
+// so that test points are numbered in manifest order
+type TAPReporter struct {
+	w io.Writer
+}
+
+func NewTAPReporter(w io.Writer) *TAPReporter {
+	return &TAPReporter{w: w}
+}
+
+func (t *TAPReporter) SuiteStarted(tests []*testing.Test) {}
+
+func (t *TAPReporter) TestStarted(test *testing.Test) {}
+
+func (t *TAPReporter) TestFinished(result *testing.TestResult) {}
+
+func (t *TAPReporter) SuiteFinished(suite *testing.SuiteResult) error {
+	var sb strings.Builder
+	sb.WriteString("TAP version 13\n")
+	sb.WriteString(fmt.Sprintf("1..%d\n", len(suite.Tests)))
+	for i, result := range suite.Tests {
+		description := result.Id
+		if result.Name != "" {
+			description += " " + result.Name
+		}
+		switch result.Status {
+		case testing.StatusPassed:
+			sb.WriteString(fmt.Sprintf("ok %d - %s\n", i+1, description))
+		case testing.StatusSkipped:
+			sb.WriteString(fmt.Sprintf("ok %d - %s # SKIP %s\n", i+1, description, result.Error))
+		default:
+			sb.WriteString(fmt.Sprintf("not ok %d - %s\n", i+1, description))
+			sb.WriteString("  ---\n")
+			sb.WriteString(fmt.Sprintf("  status: %s\n", result.Status))
+			sb.WriteString(fmt.Sprintf("  phase: %s\n", result.Phase))
+			if result.Error != "" {
+				sb.WriteString(fmt.Sprintf("  message: %q\n", result.Error))
+			}
+			if len(result.Diffs) > 0 {
+				sb.WriteString("  diffs:\n")
+				for _, diff := range result.Diffs {
+					sb.WriteString(fmt.Sprintf("    - %q\n", renderDiff(diff, result.Namespaces)))
+				}
+			}
+			if len(result.TransformLogs) > 0 {
+				sb.WriteString("  transformLogs:\n")
+				for _, entry := range result.TransformLogs {
+					sb.WriteString(fmt.Sprintf("    - %q\n", entry.String()))
+				}
+			}
+			if len(result.Logs) > 0 {
+				sb.WriteString("  logs:\n")
+				for _, entry := range result.Logs {
+					sb.WriteString(fmt.Sprintf("    - %q\n", entry.String()))
+				}
+			}
+			sb.WriteString("  ...\n")
+		}
+	}
+	_, err := io.WriteString(t.w, sb.String())
+	return err
+}
@@ -0,0 +1,41 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	gotesting "testing"
+)
+
+func TestTAPReporter(t *gotesting.T) {
+	suite := exampleSuite()
+	var out bytes.Buffer
+	if err := NewTAPReporter(&out).SuiteFinished(suite); err != nil {
+		t.Fatal(err)
+	}
+	failed := suite.Tests[1]
+	expected := []string{
+		"TAP version 13",
+		"1..4",
+		"ok 1 - passed",
+		"not ok 2 - failed Animals",
+		"  ---",
+		"  status: failed",
+		"  phase: compare",
+		"  diffs:",
+		fmt.Sprintf("    - %q", renderDiff(failed.Diffs[0], failed.Namespaces)),
+		fmt.Sprintf("    - %q", renderDiff(failed.Diffs[1], failed.Namespaces)),
+		"  ...",
+		"not ok 3 - errored",
+		"  ---",
+		"  status: error",
+		"  phase: run",
+		`  message: "job failed"`,
+		"  ...",
+		"ok 4 - skipped # SKIP stopped at failed",
+		"",
+	}
+	if out.String() != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), out.String())
+	}
+}
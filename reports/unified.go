@@ -0,0 +1,176 @@
+package reports
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"strings"
+)
+
+const (
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorCyan  = "\033[36m"
+	colorBold  = "\033[1m"
+)
+
+// diffContext is the number of unchanged lines shown around changed lines in unified diffs
+const diffContext = 3
+
+type lineOp struct {
+	op   byte // ' ' for unchanged, '-' for expected only, '+' for result only
+	line string
+}
+
+// UnifiedEntityDiff renders a unified diff of the json of the expected and result entity.
+// A missing or extra entity is rendered as removed or added in full.
+func UnifiedEntityDiff(comparison *testing.EntityComparison, namespaces testing.Namespaces, color bool) string {
+	expectedLabel, resultLabel := "expected", "result"
+	if comparison.Expected == nil {
+		expectedLabel = "expected (not found)"
+	}
+	if comparison.Result == nil {
+		resultLabel = "result (not found)"
+	}
+	id := namespaces.Prefixed(comparison.Id)
+
+	var sb strings.Builder
+	writeLine(&sb, color, colorBold, fmt.Sprintf("--- %s %s", expectedLabel, id))
+	writeLine(&sb, color, colorBold, fmt.Sprintf("+++ %s %s", resultLabel, id))
+	ops := diffLines(entityLines(comparison.Expected, namespaces), entityLines(comparison.Result, namespaces))
+	for _, line := range unifiedHunks(ops, diffContext) {
+		switch line[0] {
+		case '-':
+			writeLine(&sb, color, colorRed, line)
+		case '+':
+			writeLine(&sb, color, colorGreen, line)
+		case '@':
+			writeLine(&sb, color, colorCyan, line)
+		default:
+			writeLine(&sb, false, "", line)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func writeLine(sb *strings.Builder, color bool, code string, line string) {
+	if color {
+		sb.WriteString(code + line + colorReset + "\n")
+	} else {
+		sb.WriteString(line + "\n")
+	}
+}
+
+// entityLines renders the entity as indented json lines with prefixed identifiers, or no lines for a nil entity
+func entityLines(entity *egdm.Entity, namespaces testing.Namespaces) []string {
+	if entity == nil {
+		return nil
+	}
+	bytes, err := json.MarshalIndent(namespaces.PrefixedEntity(entity), "", "  ")
+	if err != nil {
+		return []string{fmt.Sprintf("failed to render entity: %s", err)}
+	}
+	return strings.Split(string(bytes), "\n")
+}
+
+// diffLines returns the line operations turning the expected lines into the result lines,
+// based on the longest common subsequence of lines
+func diffLines(expected, result []string) []lineOp {
+	n, m := len(expected), len(result)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if expected[i] == result[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []lineOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case expected[i] == result[j]:
+			ops = append(ops, lineOp{' ', expected[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, lineOp{'-', expected[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{'+', result[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, lineOp{'-', expected[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, lineOp{'+', result[j]})
+	}
+	return ops
+}
+
+// unifiedHunks formats the line operations as unified diff hunks with the given number of context lines
+func unifiedHunks(ops []lineOp, context int) []string {
+	var lines []string
+	expectedLine, resultLine := 1, 1
+	for start := 0; start < len(ops); {
+		// find the next change
+		first := start
+		for first < len(ops) && ops[first].op == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		// extend the hunk until more than 2*context unchanged lines follow a change
+		last := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].op != ' ' {
+				last = k
+			} else if k-last > 2*context {
+				break
+			}
+		}
+		from := max(first-context, start)
+		to := min(last+context+1, len(ops))
+
+		for k := start; k < from; k++ {
+			expectedLine++
+			resultLine++
+		}
+		expectedCount, resultCount := 0, 0
+		var body []string
+		for _, op := range ops[from:to] {
+			body = append(body, string(op.op)+op.line)
+			if op.op != '+' {
+				expectedCount++
+			}
+			if op.op != '-' {
+				resultCount++
+			}
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%s +%s @@", hunkRange(expectedLine, expectedCount), hunkRange(resultLine, resultCount)))
+		lines = append(lines, body...)
+		expectedLine += expectedCount
+		resultLine += resultCount
+		start = to
+	}
+	return lines
+}
+
+// hunkRange formats the start line and line count of a hunk, where an empty range starts at the line before
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,80 @@
+package reports
+
+import (
+	"reflect"
+	gotesting "testing"
+)
+
+// opString renders line operations like a unified diff body without hunk headers
+func opString(ops []lineOp) []string {
+	var lines []string
+	for _, op := range ops {
+		lines = append(lines, string(op.op)+op.line)
+	}
+	return lines
+}
+
+func TestDiffLines(t *gotesting.T) {
+	tests := []struct {
+		name     string
+		expected []string
+		result   []string
+		ops      []string
+	}{
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, []string{" a", " b"}},
+		{"changed line", []string{"a", "b", "c"}, []string{"a", "x", "c"}, []string{" a", "-b", "+x", " c"}},
+		{"added lines", []string{"a"}, []string{"a", "b", "c"}, []string{" a", "+b", "+c"}},
+		{"removed lines", []string{"a", "b", "c"}, []string{"c"}, []string{"-a", "-b", " c"}},
+		{"no expected lines", nil, []string{"a", "b"}, []string{"+a", "+b"}},
+		{"no result lines", []string{"a", "b"}, nil, []string{"-a", "-b"}},
+		{"both empty", nil, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			if ops := opString(diffLines(test.expected, test.result)); !reflect.DeepEqual(ops, test.ops) {
+				t.Errorf("expected %q, got %q", test.ops, ops)
+			}
+		})
+	}
+}
+
+func TestUnifiedHunks(t *gotesting.T) {
+	lines := func(from, to int) []string {
+		var l []string
+		for i := from; i <= to; i++ {
+			l = append(l, string(rune('a'+i-1)))
+		}
+		return l
+	}
+	tests := []struct {
+		name     string
+		expected []string
+		result   []string
+		context  int
+		hunks    []string
+	}{
+		{"equal", lines(1, 3), lines(1, 3), 3, nil},
+		{"changed line with context", lines(1, 5), []string{"a", "b", "x", "d", "e"}, 1,
+			[]string{"@@ -2,3 +2,3 @@", " b", "-c", "+x", " d"}},
+		{"added entity", nil, lines(1, 2), 3,
+			[]string{"@@ -0,0 +1,2 @@", "+a", "+b"}},
+		{"missing entity", lines(1, 2), nil, 3,
+			[]string{"@@ -1,2 +0,0 @@", "-a", "-b"}},
+		{"insertion without context", lines(1, 2), []string{"a", "x", "b"}, 0,
+			[]string{"@@ -1,0 +2,1 @@", "+x"}},
+		{"removal without context", []string{"a", "x", "b"}, lines(1, 2), 0,
+			[]string{"@@ -2,1 +1,0 @@", "-x"}},
+		{"separate hunks", lines(1, 10), []string{"x", "b", "c", "d", "e", "f", "g", "h", "i", "y"}, 1,
+			[]string{"@@ -1,2 +1,2 @@", "-a", "+x", " b", "@@ -9,2 +9,2 @@", " i", "-j", "+y"}},
+		{"joined hunks", lines(1, 4), []string{"x", "b", "c", "y"}, 1,
+			[]string{"@@ -1,4 +1,4 @@", "-a", "+x", " b", " c", "-d", "+y"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			hunks := unifiedHunks(diffLines(test.expected, test.result), test.context)
+			if !reflect.DeepEqual(hunks, test.hunks) {
+				t.Errorf("expected %q, got %q", test.hunks, hunks)
+			}
+		})
+	}
+}
@@ -1,170 +1,792 @@
 package datahub_job_testing
 
 import (
+	"errors"
 	"fmt"
 	"github.com/mimiro-io/datahub-client-sdk-go"
 	"github.com/mimiro-io/datahub-job-testing/jobs"
+	"github.com/mimiro-io/datahub-job-testing/reports"
 	"github.com/mimiro-io/datahub-job-testing/testing"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"go.uber.org/zap/zapcore"
 	"log"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type TestRunner struct {
-	Manifest *testing.Manifest
+	Manifest    *testing.Manifest
+	Parallelism int // number of tests running concurrently, each on its own datahub instance
+	// ReuseDatahub keeps one datahub instance per worker and resets it between tests, except for isolated tests
+	ReuseDatahub bool
+	// KeepOnFailure stops the run at the first failing test and keeps its datahub running until the process is
+	// interrupted, the remaining tests are skipped
+	KeepOnFailure bool
+	LogLevel      zapcore.Level // minimum level of the datahub log messages kept on test results, transform messages are always kept
+	Reporters     []reports.Reporter
+	// UpdateSnapshots writes the sink entities to the expected output file of each test instead of comparing them
+	UpdateSnapshots bool
+	Verbose         bool // include ignored diffs in test results
+	reportMu        sync.Mutex
+	keepMu          sync.Mutex
+	failedTest      string       // id of the first failing test when KeepOnFailure is set
+	kept            *testDatahub // datahub of the first failing test when KeepOnFailure is set
 }
 
 func NewTestRunner(manifestPath string) *TestRunner {
 	return &TestRunner{
-		Manifest: testing.LoadManifest(manifestPath),
+		Manifest:    testing.LoadManifest(manifestPath),
+		Parallelism: 1,
+		LogLevel:    zapcore.InfoLevel,
+		Reporters:   []reports.Reporter{reports.NewConsoleReporter()},
 	}
 }
 
-func (tr *TestRunner) RunSingleTest(testId string) ([]testing.Diff, bool) {
-	return tr.runTests(testId)
+// WithUpdateSnapshots enables or disables rewriting expected output files from the job output
+func (tr *TestRunner) WithUpdateSnapshots(update bool) *TestRunner {
+	tr.UpdateSnapshots = update
+	return tr
 }
 
-func (tr *TestRunner) RunAllTests() bool {
-	_, success := tr.runTests("")
-	return success
+// WithVerbose enables or disables verbose comparison output
+func (tr *TestRunner) WithVerbose(verbose bool) *TestRunner {
+	tr.Verbose = verbose
+	return tr
 }
 
-func (tr *TestRunner) runTests(testId string) ([]testing.Diff, bool) {
-	successfulCount := 0
-	startedTests := 0
-	var diffs []testing.Diff
+// AddReporter registers a reporter that receives events from all following test runs
+func (tr *TestRunner) AddReporter(reporter reports.Reporter) *TestRunner {
+	tr.Reporters = append(tr.Reporters, reporter)
+	return tr
+}
+
+// WithReuseDatahub enables or disables running tests on one datahub per worker, reset between tests
+func (tr *TestRunner) WithReuseDatahub(reuse bool) *TestRunner {
+	tr.ReuseDatahub = reuse
+	return tr
+}
+
+// WithKeepOnFailure enables or disables stopping at the first failing test and keeping its datahub running
+func (tr *TestRunner) WithKeepOnFailure(keep bool) *TestRunner {
+	tr.KeepOnFailure = keep
+	return tr
+}
+
+// WithLogLevel sets the minimum level of the datahub log messages kept on test results
+func (tr *TestRunner) WithLogLevel(level zapcore.Level) *TestRunner {
+	tr.LogLevel = level
+	return tr
+}
+
+// WithParallelism sets the number of workers used to run tests concurrently
+func (tr *TestRunner) WithParallelism(workers int) *TestRunner {
+	tr.Parallelism = workers
+	return tr
+}
+
+// RunSingleTest runs the test with the given id. The diffs of the test are kept on its result in the suite.
+func (tr *TestRunner) RunSingleTest(testId string) *testing.SuiteResult {
+	return tr.runTests(testId)
+}
+
+// RunAllTests runs all tests in the manifest. Each test result holds the diffs of that test only,
+// use DiffsByTest on the suite result to get the diffs grouped by test id.
+func (tr *TestRunner) RunAllTests() *testing.SuiteResult {
+	return tr.runTests("")
+}
 
+func (tr *TestRunner) runTests(testId string) *testing.SuiteResult {
+	start := time.Now()
+	// the first failure of a previous run must not skip the tests of this run
+	tr.keepMu.Lock()
+	tr.failedTest = ""
+	tr.kept = nil
+	tr.keepMu.Unlock()
+	var selected []*testing.Test
 	for _, test := range tr.Manifest.Tests {
 		if testId != "" && test.Id != testId {
 			continue
 		}
+		selected = append(selected, test)
+	}
+	if len(selected) == 0 && testId != "" {
+		// report an unknown test id as an errored test instead of exiting, the runner may be embedded in a program
+		unknown := &testing.Test{Id: testId}
+		tr.report(func(r reports.Reporter) { r.SuiteStarted([]*testing.Test{unknown}) })
+		result := testing.NewTestResult(unknown)
+		result.SetError(testing.PhaseSetup, fmt.Errorf("no test found with id %s", testId))
+		tr.report(func(r reports.Reporter) { r.TestFinished(result) })
+		suite := &testing.SuiteResult{Tests: []*testing.TestResult{result}, Duration: time.Since(start)}
+		tr.finishSuite(suite)
+		return suite
+	}
+
+	workers := tr.Parallelism
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(selected) {
+		workers = len(selected)
+	}
+
+	tr.report(func(r reports.Reporter) { r.SuiteStarted(selected) })
+
+	// each worker picks the next test from the queue and stores the result at the test's position,
+	// so that results are aggregated in manifest order regardless of completion order
+	suite := &testing.SuiteResult{Tests: make([]*testing.TestResult, len(selected))}
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// datahub kept alive between the tests of this worker when datahubs are reused
+			var shared *testDatahub
+			defer func() {
+				if shared != nil {
+					shared.manager.Cleanup()
+				}
+			}()
+			for i := range queue {
+				if failedTest := tr.stoppedAt(); failedTest != "" {
+					result := testing.NewTestResult(selected[i])
+					result.SetSkipped(fmt.Sprintf("stopped after test %s failed", failedTest))
+					suite.Tests[i] = result
+					tr.report(func(r reports.Reporter) { r.TestFinished(result) })
+					continue
+				}
+				tr.report(func(r reports.Reporter) { r.TestStarted(selected[i]) })
+				result := tr.runTest(selected[i], &shared)
+				// stops the run also for tests failing before they got a datahub
+				tr.keepOnFailure(selected[i], nil, result)
+				suite.Tests[i] = result
+				tr.report(func(r reports.Reporter) { r.TestFinished(result) })
+			}
+		}()
+	}
+	for i := range selected {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	suite.Duration = time.Since(start)
 
-		startedTests++
+	tr.finishSuite(suite)
+	if tr.kept != nil {
+		tr.holdDatahub()
+	} else if tr.failedTest != "" {
+		log.Printf("Test %s failed before its datahub started, no datahub to keep", tr.failedTest)
+	}
+	return suite
+}
 
-		// startup data hub instance
-		dm, err := testing.StartTestDatahub("10778")
+// finishSuite reports the finished suite and keeps the errors of reporters failing to write their output on it
+func (tr *TestRunner) finishSuite(suite *testing.SuiteResult) {
+	var reportErrors []error
+	tr.report(func(r reports.Reporter) {
+		err := r.SuiteFinished(suite)
 		if err != nil {
-			log.Printf("failed to start test datahub for test %s: %s", test.Id, err)
-			continue
+			log.Printf("failed to write report: %s", err)
+			reportErrors = append(reportErrors, err)
 		}
+	})
+	suite.ReportError = errors.Join(reportErrors...)
+}
+
+// stoppedAt returns the id of the failing test the run stopped at, or an empty string if the run goes on
+func (tr *TestRunner) stoppedAt() string {
+	tr.keepMu.Lock()
+	defer tr.keepMu.Unlock()
+	return tr.failedTest
+}
+
+// keepOnFailure stops the run if the test failed and KeepOnFailure is set. The datahub of the first failing test
+// is kept running, and true is returned if the given datahub was kept.
+func (tr *TestRunner) keepOnFailure(test *testing.Test, hub *testDatahub, result *testing.TestResult) bool {
+	if !tr.KeepOnFailure || result.Passed() {
+		return false
+	}
+	tr.keepMu.Lock()
+	defer tr.keepMu.Unlock()
+	if tr.failedTest != "" {
+		return false
+	}
+	tr.failedTest = test.Id
+	tr.kept = hub
+	return hub != nil
+}
 
-		// create client
-		client, err := datahub.NewClient("http://localhost:10778")
+// holdDatahub logs where to find the datahub of the failing test and keeps it running until the process is interrupted
+func (tr *TestRunner) holdDatahub() {
+	hub := tr.kept
+	log.Printf("Keeping the datahub of failed test %s running at %s", tr.failedTest, hub.manager.URL())
+	log.Printf("Temporary store: %s", hub.manager.Location)
+	datasets, err := hub.manager.Datasets(hub.client)
+	if err != nil {
+		log.Printf("Datasets: %s", err)
+	} else {
+		log.Printf("Datasets: %s", strings.Join(datasets, ", "))
+	}
+	log.Printf("Press Ctrl+C to stop the datahub and remove the store")
+	waitForInterrupt()
+
+	hub.manager.Cleanup()
+	tr.kept = nil
+}
+
+// waitForInterrupt blocks until the process is interrupted, tests replace it to release a kept datahub
+var waitForInterrupt = func() {
+	// the datahub exits the process on interrupt, replace its handler to remove the store first
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	signal.Stop(interrupt)
+}
+
+// report delivers an event to all registered reporters, one event at a time
+func (tr *TestRunner) report(event func(r reports.Reporter)) {
+	tr.reportMu.Lock()
+	defer tr.reportMu.Unlock()
+	for _, reporter := range tr.Reporters {
+		event(reporter)
+	}
+}
+
+// testDatahub is a running datahub instance with a client connected to it
+type testDatahub struct {
+	manager *testing.DatahubManager
+	client  *datahub.Client
+}
+
+// startDatahub starts a datahub instance on a free port and connects a client to it
+func (tr *TestRunner) startDatahub() (*testDatahub, error) {
+	dm, err := testing.StartTestDatahubWithLogLevel("", tr.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start test datahub: %w", err)
+	}
+	client, err := datahub.NewClient(dm.URL())
+	if err != nil {
+		dm.Cleanup()
+		return nil, fmt.Errorf("failed to create datahub client: %w", err)
+	}
+	return &testDatahub{manager: dm, client: client}, nil
+}
+
+// acquireDatahub returns the datahub to run the test on, and a function that releases it when the test is done.
+// When datahubs are reused, the worker's shared datahub is started on first use and reset on release.
+// Isolated tests, and all tests when datahubs are not reused, run on a fresh datahub that is removed on release.
+func (tr *TestRunner) acquireDatahub(test *testing.Test, shared **testDatahub) (*testDatahub, func(), error) {
+	if !tr.ReuseDatahub || test.Isolated {
+		hub, err := tr.startDatahub()
 		if err != nil {
-			log.Printf("failed to create datahub client for test %s: %s", test.Id, err)
-			dm.Cleanup()
-			continue
+			return nil, nil, err
 		}
+		return hub, hub.manager.Cleanup, nil
+	}
+	if *shared == nil {
+		hub, err := tr.startDatahub()
+		if err != nil {
+			return nil, nil, err
+		}
+		*shared = hub
+	}
+	hub := *shared
+	// drop messages logged while resetting the datahub after the previous test
+	hub.manager.Logs.Take()
+	release := func() {
+		err := hub.manager.Reset(hub.client)
+		if err != nil {
+			// start over with a fresh datahub for the next test rather than leaking state into it
+			log.Printf("Failed to reset datahub after test %s, starting a new one: %s", test.Id, err)
+			hub.manager.Cleanup()
+			*shared = nil
+		}
+	}
+	return hub, release, nil
+}
 
-		// upload required datasets
-		for _, dataset := range test.RequiredDatasets {
-			existInCommon := false
-			if test.IncludeCommon {
-				for _, commonDataset := range tr.Manifest.Common.RequiredDatasets {
-					if dataset.Name == commonDataset.Name {
-						existInCommon = true
-						log.Printf("Required dataset %s found in common datasets. Will not upload", dataset.Name)
-						break
-					}
-				}
-			}
-			if !existInCommon {
-				err := testing.LoadEntities(dataset, client)
-				if err != nil {
-					log.Printf("failed to load required dataset %s for test %s: %s", dataset.Name, test.Id, err)
-					dm.Cleanup()
-					continue
-				}
-			}
+// runTest runs a single test and records the outcome in a TestResult. The test runs on its own datahub instance,
+// or on the worker's shared instance when datahubs are reused.
+func (tr *TestRunner) runTest(test *testing.Test, shared **testDatahub) *testing.TestResult {
+	start := time.Now()
+	result := testing.NewTestResult(test)
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
 
+	if test.Job == nil {
+		paths := test.JobPath
+		if len(test.JobPaths) > 0 {
+			paths = strings.Join(test.JobPaths, ", ")
+		}
+		result.SetError(testing.PhaseSetup, fmt.Errorf("no job loaded from %s", paths))
+		return result
+	}
+	switch test.CompareMode {
+	case "", testing.CompareExact, testing.CompareSubset:
+	default:
+		result.SetError(testing.PhaseSetup, fmt.Errorf("unknown compare mode '%s'", test.CompareMode))
+		return result
+	}
+	for _, rule := range tr.compareOptions(test).Ignore {
+		if err := rule.Validate(); err != nil {
+			result.SetError(testing.PhaseSetup, err)
+			return result
+		}
+	}
+	for _, assertion := range tr.logAssertions(test) {
+		if err := assertion.Validate(); err != nil {
+			result.SetError(testing.PhaseSetup, fmt.Errorf("expected logs: %w", err))
+			return result
+		}
+	}
+	if len(test.Phases) > 0 || len(test.Steps) > 0 {
+		err := tr.validateSteps(test)
+		if err != nil {
+			result.SetError(testing.PhaseSetup, err)
+			return result
 		}
+	} else {
+		err := tr.validateExpectedOutputs(test)
+		if err != nil {
+			result.SetError(testing.PhaseSetup, err)
+			return result
+		}
+	}
+	result.Namespaces = test.ExpectedNamespaces().Merge(tr.Manifest.Namespaces)
 
-		if test.IncludeCommon && tr.Manifest.Common.RequiredDatasets != nil {
-			for _, dataset := range tr.Manifest.Common.RequiredDatasets {
-				err := testing.LoadEntities(dataset, client)
-				if err != nil {
-					log.Printf("failed to load required dataset %s for test %s: %s. Will exit", dataset.Name, test.Id, err)
-					dm.Cleanup()
-					os.Exit(1)
-				}
+	hub, release, err := tr.acquireDatahub(test, shared)
+	if err != nil {
+		result.SetError(testing.PhaseSetup, err)
+		return result
+	}
+	defer func() {
+		result.AddLogs(hub.manager.Logs.Take())
+		if tr.keepOnFailure(test, hub, result) {
+			if *shared == hub {
+				// the worker must not remove the kept datahub when it is done
+				*shared = nil
 			}
+			return
 		}
+		release()
+	}()
+	client := hub.client
+
+	// upload required datasets, common datasets replace test datasets with the same name
+	for _, dataset := range test.RequiredDatasets {
+		if tr.Manifest.RequiredDataset(test, dataset.Name) != dataset {
+			log.Printf("Required dataset %s found in common datasets. Will not upload", dataset.Name)
+		}
+	}
+	for _, dataset := range tr.Manifest.RequiredDatasets(test) {
+		err := testing.LoadEntities(dataset, client)
+		if err != nil {
+			result.SetError(testing.PhaseDatasetUpload, fmt.Errorf("failed to load required dataset %s: %w", dataset.Name, err))
+			return result
+		}
+	}
+	// upload jobs and create their sink datasets
+	for _, job := range test.PipelineJobs() {
 		// if job source dataset is http source, we convert it to regular DatasetSource to run the test without external dependencies
-		if test.Job.Source["Type"].(string) == "HttpDatasetSource" {
-			re := regexp.MustCompile(`datasets/(.+)/(changes|entities)`)
-			matches := re.FindStringSubmatch(test.Job.Source["Url"].(string))
-			if len(matches) > 1 {
-				test.Job.Source["Name"] = matches[1]
-				test.Job.Source["Type"] = "DatasetSource"
-			} else {
-				log.Printf("failed to parse dataset name from http source url: %s", test.Job.Source["Url"])
-				dm.Cleanup()
-				continue
-			}
+		err = testing.LocalSource(job)
+		if err != nil {
+			result.SetError(testing.PhaseJobUpload, err)
+			return result
 		}
-
-		// upload job
-		err = client.AddJob(test.Job)
+		err = client.AddJob(job)
 		if err != nil {
-			log.Printf("failed to upload job for test %s: %s", test.Id, err)
-			dm.Cleanup()
-			continue
+			result.SetError(testing.PhaseJobUpload, fmt.Errorf("failed to upload job %s: %w", job.Id, err))
+			return result
 		}
-
-		// Create job sink dataset
-		err = client.AddDataset(test.Job.Sink["Name"].(string), nil)
+		err = testing.EnsureDataset(testing.SinkDataset(job), client)
 		if err != nil {
-			log.Printf("failed to create sink dataset for test %s: %s", test.Id, err)
-			dm.Cleanup()
-			continue
+			result.SetError(testing.PhaseJobUpload, fmt.Errorf("failed to create sink dataset of job %s: %w", job.Id, err))
+			return result
 		}
-
-		// run job
-		err = jobs.RunAndWait(client, test.Job.Id)
+	}
+	sinkName := testing.SinkDataset(test.Job)
+	// datasets written by transforms must exist before the run
+	for dataset := range test.ExpectedOutputs {
+		err = testing.EnsureDataset(dataset, client)
 		if err != nil {
-			log.Printf("failed to run job for test %s: %s", test.Id, err)
-			dm.Cleanup()
-			continue
+			result.SetError(testing.PhaseJobUpload, fmt.Errorf("failed to create dataset %s: %w", dataset, err))
+			return result
+		}
+	}
+
+	if len(test.Phases) > 0 || len(test.Steps) > 0 {
+		tr.runSteps(test, client, sinkName, result)
+		tr.assertLogs(test, hub, result)
+		return result
+	}
+
+	// run job
+	err = tr.runJobs(test, client, testing.RunFullSync)
+	if err != nil {
+		setRunError(result, err)
+		return result
+	}
+
+	// compare output, the expected output of the test is optional with expected outputs per dataset
+	if test.ExpectedOutputPath != "" || len(test.ExpectedOutputs) == 0 {
+		if !tr.assertOutput(test, client, sinkName, test.ExpectedOutput, test.ExpectedOutputPath, "", result) {
+			return result
+		}
+	}
+	for _, dataset := range sortedKeys(test.ExpectedOutputs) {
+		if !tr.assertOutput(test, client, dataset, test.ExpectedDatasets[dataset], test.ExpectedOutputs[dataset], dataset, result) {
+			return result
+		}
+	}
+	for _, name := range test.UnchangedDatasets {
+		tr.assertUnchanged(test, client, tr.Manifest.RequiredDataset(test, name), result)
+	}
+	tr.assertLogs(test, hub, result)
+	return result
+}
+
+// logAssertions returns the expected logs of the test, including common expected logs if the test includes common
+func (tr *TestRunner) logAssertions(test *testing.Test) []*testing.LogAssertion {
+	assertions := test.ExpectedLogs
+	if test.IncludeCommon {
+		assertions = append(append([]*testing.LogAssertion{}, assertions...), tr.Manifest.Common.ExpectedLogs...)
+	}
+	return assertions
+}
+
+// assertLogs collects the messages logged while running the test, and checks the messages logged by transforms
+// against the expected logs of the test
+func (tr *TestRunner) assertLogs(test *testing.Test, hub *testDatahub, result *testing.TestResult) {
+	result.AddLogs(hub.manager.Logs.Take())
+	assertions := tr.logAssertions(test)
+	if len(assertions) == 0 || result.Status == testing.StatusError {
+		return
+	}
+	equal, diffs := testing.CompareLogs(assertions, result.TransformLogs)
+	result.AddComparison("logs", equal, diffs)
+}
+
+// assertUnchanged compares the entities in a required dataset with the entities loaded into it before the run
+func (tr *TestRunner) assertUnchanged(test *testing.Test, client *datahub.Client, dataset *testing.StoredDataset, result *testing.TestResult) {
+	assertion := dataset.Name + " unchanged"
+	entities, err := client.GetEntities(dataset.Name, "", 0, false, true)
+	if err != nil {
+		result.SetError(testing.PhaseCompare, fmt.Errorf("%s: failed to get entities from dataset %s: %w", assertion, dataset.Name, err))
+		return
+	}
+	log.Printf("Found %d entities in dataset %s for test %s", len(entities.GetEntities()), dataset.Name, test.Id)
+	options := &testing.CompareOptions{Verbose: tr.Verbose}
+	equal, diffs := testing.CompareEntities(dataset.EntityCollection, entities, options)
+	result.AddComparison(assertion, equal, diffs)
+	result.Entities = append(result.Entities, testing.CompareEntitiesById(dataset.EntityCollection, entities, diffs, options.Verbose)...)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateExpectedOutputs checks that the expected outputs of a test with a single run are loaded,
+// and that datasets asserted to be unchanged are required datasets of the test
+func (tr *TestRunner) validateExpectedOutputs(test *testing.Test) error {
+	if (test.ExpectedOutputPath != "" || len(test.ExpectedOutputs) == 0) && test.ExpectedOutput == nil && !tr.UpdateSnapshots {
+		return fmt.Errorf("no expected output loaded from %s", test.ExpectedOutputPath)
+	}
+	for dataset, path := range test.ExpectedOutputs {
+		if test.ExpectedDatasets[dataset] == nil && !tr.UpdateSnapshots {
+			return fmt.Errorf("no expected output loaded from %s", path)
+		}
+	}
+	for _, name := range test.UnchangedDatasets {
+		dataset := tr.Manifest.RequiredDataset(test, name)
+		if dataset == nil || dataset.EntityCollection == nil {
+			return fmt.Errorf("dataset %s is asserted to be unchanged, but is not a required dataset of the test", name)
 		}
+	}
+	return nil
+}
 
-		// compare output
-		entities, err := client.GetEntities(test.Job.Sink["Name"].(string), "", 0, false, true)
+// runJobs runs the jobs of the test in order and waits for each of them to finish
+func (tr *TestRunner) runJobs(test *testing.Test, client *datahub.Client, mode testing.RunMode) error {
+	run := jobs.RunAndWait
+	if mode == testing.RunIncremental {
+		run = jobs.RunIncrementalAndWait
+	}
+	for _, job := range test.PipelineJobs() {
+		err := run(client, job.Id)
 		if err != nil {
-			log.Printf("failed to get entities from sink dataset for test %s: %s", test.Id, err)
-			dm.Cleanup()
-			continue
+			if len(test.Jobs) > 1 {
+				return fmt.Errorf("failed to run job %s: %w", job.Id, err)
+			}
+			return fmt.Errorf("failed to run job: %w", err)
 		}
-		if len(entities.GetEntities()) == 0 {
-			log.Printf("No entities found in sink dataset for test %s", test.Id)
-			dm.Cleanup()
-			continue
+	}
+	return nil
+}
+
+// setRunError marks the result as errored in the run phase, with the error the datahub reported for the job if any
+func setRunError(result *testing.TestResult, err error) {
+	result.SetError(testing.PhaseRun, err)
+	var jobError *jobs.JobError
+	if errors.As(err, &jobError) {
+		result.JobError = jobError.LastError
+	}
+}
+
+// validateSteps checks that the steps of a scenario test, or the phases expanded to steps, can run
+func (tr *TestRunner) validateSteps(test *testing.Test) error {
+	if len(test.Phases) > 0 && len(test.Steps) > 0 {
+		return fmt.Errorf("a test can have either phases or steps, not both")
+	}
+	if len(test.ExpectedOutputs) > 0 || len(test.UnchangedDatasets) > 0 {
+		return fmt.Errorf("expectedOutputs and unchangedDatasets are not supported in tests with phases or steps, use assertions instead")
+	}
+	for i, phase := range test.Phases {
+		if phase.ExpectedOutputPath == "" && phase.ExpectedChangesPath == "" && len(phase.ExpectedVersions) == 0 {
+			return fmt.Errorf("%s: no expected output, expected changes or expected versions defined", phase.Label(i))
 		}
-		log.Printf("Found %d entities in sink dataset for test %s", len(entities.GetEntities()), test.Id)
+	}
+	for i, step := range test.Scenario() {
+		label := step.Label(i)
+		switch step.Type {
+		case testing.StepUpload:
+			if step.Dataset == "" || step.Entities == nil {
+				return fmt.Errorf("%s: upload needs a dataset and entities loaded from a path", label)
+			}
+		case testing.StepDelete:
+			if step.Dataset == "" || (step.Entities == nil && len(step.Ids) == 0) {
+				return fmt.Errorf("%s: delete needs a dataset and entities loaded from a path or ids", label)
+			}
+		case testing.StepRun:
+			switch step.RunMode() {
+			case testing.RunFullSync, testing.RunIncremental:
+			default:
+				return fmt.Errorf("%s: unknown run mode '%s'", label, step.Mode)
+			}
+		case testing.StepAssertDataset, testing.StepAssertChanges:
+			if step.ExpectedPath == "" {
+				return fmt.Errorf("%s: no expected entities defined", label)
+			}
+			if step.Expected == nil && !tr.UpdateSnapshots {
+				return fmt.Errorf("%s: no expected entities loaded from %s", label, step.ExpectedPath)
+			}
+		case testing.StepAssertHistory:
+			if step.ExpectedPath == "" && len(step.Versions) == 0 {
+				return fmt.Errorf("%s: no expected entities or versions defined", label)
+			}
+			if step.ExpectedPath != "" && step.Expected == nil && !tr.UpdateSnapshots {
+				return fmt.Errorf("%s: no expected entities loaded from %s", label, step.ExpectedPath)
+			}
+			if _, err := step.ExpectedVersions(tr.Manifest.Namespaces); err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+		default:
+			return fmt.Errorf("%s: unknown step type '%s'", label, step.Type)
+		}
+	}
+	return nil
+}
 
-		dm.Cleanup()
+// runSteps runs the steps of a scenario test in order. Assertions default to the job sink. Changes are compared
+// with the latest version of the entities changed in the dataset since the last run step started.
+func (tr *TestRunner) runSteps(test *testing.Test, client *datahub.Client, sinkName string, result *testing.TestResult) {
+	steps := test.Scenario()
 
-		equal, entityDiff := testing.CompareEntities(test.ExpectedOutput, entities)
-		if !equal {
-			diffs = append(diffs, entityDiff...)
-			log.Printf("Listing diffs for test %s", test.Id)
-			logDiffs(diffs, test.Id)
-		} else {
-			successfulCount++
+	// continuation tokens of the datasets with changes assertions, updated when a run step starts
+	since := map[string]string{}
+	for _, step := range steps {
+		if step.Type == testing.StepAssertChanges {
+			since[datasetOrSink(step, sinkName)] = ""
+		}
+	}
+
+	for i, step := range steps {
+		label := step.Label(i)
+		dataset := datasetOrSink(step, sinkName)
+		switch step.Type {
+		case testing.StepUpload:
+			err := testing.StoreEntities(&testing.StoredDataset{Name: dataset, Path: step.Path, EntityCollection: step.Entities}, client)
+			if err != nil {
+				result.SetError(testing.PhaseDatasetUpload, fmt.Errorf("%s: failed to store entities in dataset %s: %w", label, dataset, err))
+				return
+			}
+		case testing.StepDelete:
+			deleted, err := step.DeletedEntities(tr.Manifest.Namespaces)
+			if err == nil {
+				err = testing.StoreEntities(&testing.StoredDataset{Name: dataset, Path: step.Path, EntityCollection: deleted}, client)
+			}
+			if err != nil {
+				result.SetError(testing.PhaseDatasetUpload, fmt.Errorf("%s: failed to delete entities in dataset %s: %w", label, dataset, err))
+				return
+			}
+		case testing.StepRun:
+			for name, token := range since {
+				changes, err := client.GetChanges(name, token, 0, true, false, true)
+				// the dataset may not exist until a later step
+				if err == nil && changes.Continuation != nil {
+					since[name] = changes.Continuation.Token
+				}
+			}
+			mode := step.RunMode()
+			log.Printf("Running %s of test %s as %s", label, test.Id, mode)
+			err := tr.runJobs(test, client, mode)
+			if err != nil {
+				setRunError(result, fmt.Errorf("%s: %w", label, err))
+				return
+			}
+		case testing.StepAssertDataset:
+			if !tr.assertOutput(test, client, dataset, step.Expected, step.ExpectedPath, label, result) {
+				return
+			}
+		case testing.StepAssertChanges:
+			changes, err := client.GetChanges(dataset, since[dataset], 0, true, false, true)
+			if err != nil {
+				result.SetError(testing.PhaseCompare, fmt.Errorf("%s: failed to get changes from dataset %s: %w", label, dataset, err))
+				return
+			}
+			log.Printf("Found %d changed entities in dataset %s for %s of test %s", len(changes.GetEntities()), dataset, label, test.Id)
+			if tr.UpdateSnapshots {
+				if !tr.updateSnapshot(step.ExpectedPath, step.Expected, changes, result) {
+					return
+				}
+				continue
+			}
+			tr.compare(test, step.Expected, changes, label, result)
+		case testing.StepAssertHistory:
+			if !tr.assertHistory(test, client, dataset, step, label, result) {
+				return
+			}
 		}
 	}
-	if startedTests == 0 && testId != "" {
-		log.Fatalf("No test found with id %s", testId)
-		return nil, false
+}
+
+// assertHistory compares all versions in the changes feed of the dataset with the expected versions of the step,
+// and the number of versions per entity id with the expected counts. Returns false if the test can not continue.
+func (tr *TestRunner) assertHistory(test *testing.Test, client *datahub.Client, dataset string, step *testing.TestStep, label string, result *testing.TestResult) bool {
+	changes, err := client.GetChanges(dataset, "", 0, false, false, true)
+	if err != nil {
+		result.SetError(testing.PhaseCompare, fmt.Errorf("%s: failed to get changes from dataset %s: %w", label, dataset, err))
+		return false
 	}
-	if successfulCount == startedTests {
-		log.Printf("All %d tests ran successfully!", startedTests)
-		return nil, true
-	} else {
-		log.Printf("Finished running %d tests. One or more tests failed", startedTests)
-		return diffs, false
+	log.Printf("Found %d versions in dataset %s for %s of test %s", len(changes.GetEntities()), dataset, label, test.Id)
+	if len(step.Versions) > 0 {
+		versions, _ := step.ExpectedVersions(tr.Manifest.Namespaces)
+		equal, diffs := testing.CompareVersionCounts(versions, changes)
+		result.AddComparison(label, equal, diffs)
 	}
+	if step.ExpectedPath == "" {
+		return true
+	}
+	if tr.UpdateSnapshots {
+		return tr.updateSnapshot(step.ExpectedPath, step.Expected, changes, result)
+	}
+	options := tr.compareOptions(test)
+	equal, diffs := testing.CompareHistory(step.Expected, changes, options)
+	result.AddComparison(label, equal, diffs)
+	result.Entities = append(result.Entities, testing.CompareEntitiesById(step.Expected, changes, diffs, options.Verbose)...)
+	return true
+}
+
+// datasetOrSink returns the dataset of the step, or the job sink if the step has none
+func datasetOrSink(step *testing.TestStep, sinkName string) string {
+	if step.Dataset != "" {
+		return step.Dataset
+	}
+	return sinkName
+}
+
+// assertOutput compares the entities in the dataset with the expected output, or writes them to the expected output
+// file in update mode. The assertion labels the diffs in tests with several expected outputs.
+// Returns false if the test can not continue.
+func (tr *TestRunner) assertOutput(test *testing.Test, client *datahub.Client, dataset string, expected *egdm.EntityCollection, expectedPath string, assertion string, result *testing.TestResult) bool {
+	prefix := ""
+	if assertion != "" {
+		prefix = assertion + ": "
+	}
+	entities, err := client.GetEntities(dataset, "", 0, false, true)
+	if err != nil {
+		result.SetError(testing.PhaseCompare, fmt.Errorf("%sfailed to get entities from dataset %s: %w", prefix, dataset, err))
+		return false
+	}
+	found := len(entities.GetEntities())
+	result.ResultEntities += found
+	// an empty dataset is only expected if the expected output is empty
+	if found == 0 && (expected == nil || len(expected.GetEntities()) > 0) {
+		result.SetError(testing.PhaseCompare, fmt.Errorf("%sno entities found in dataset %s", prefix, dataset))
+		return false
+	}
+	log.Printf("Found %d entities in dataset %s for test %s", found, dataset, test.Id)
+
+	if tr.UpdateSnapshots {
+		return tr.updateSnapshot(expectedPath, expected, entities, result)
+	}
+	result.ExpectedEntities += len(expected.GetEntities())
+	tr.compare(test, expected, entities, assertion, result)
+	return true
+}
+
+// compare adds the comparison of the expected and result entities to the test result
+func (tr *TestRunner) compare(test *testing.Test, expected, entities *egdm.EntityCollection, assertion string, result *testing.TestResult) {
+	options := tr.compareOptions(test)
+	equal, diffs := testing.CompareEntities(expected, entities, options)
+	result.AddComparison(assertion, equal, diffs)
+	result.Entities = append(result.Entities, testing.CompareEntitiesById(expected, entities, diffs, options.Verbose)...)
+}
+
+// compareOptions returns the comparison options for the test, including common options if the test includes common
+func (tr *TestRunner) compareOptions(test *testing.Test) *testing.CompareOptions {
+	options := &testing.CompareOptions{
+		Mode:           test.CompareMode,
+		UnorderedLists: test.UnorderedLists,
+		Numeric:        test.Numeric,
+		Verbose:        tr.Verbose,
+	}
+	options.Ignore = append(options.Ignore, test.Ignore...)
+	options.UnorderedKeys = append(options.UnorderedKeys, test.UnorderedKeys...)
+	if test.IncludeCommon {
+		common := tr.Manifest.Common
+		options.Ignore = append(options.Ignore, common.Ignore...)
+		options.UnorderedKeys = append(options.UnorderedKeys, common.UnorderedKeys...)
+		options.UnorderedLists = options.UnorderedLists || common.UnorderedLists
+		if options.Numeric == nil {
+			options.Numeric = common.Numeric
+		}
+	}
+	return options
+}
+
+// updateSnapshot writes the sink entities over the expected output file, keeping the namespace prefixes of the
+// existing expected output. Returns false if the file could not be written.
+func (tr *TestRunner) updateSnapshot(path string, expected *egdm.EntityCollection, entities *egdm.EntityCollection, result *testing.TestResult) bool {
+	if path == "" {
+		result.SetError(testing.PhaseSnapshot, fmt.Errorf("no expected output path defined"))
+		return false
+	}
+	var namespaces egdm.NamespaceManager
+	if expected != nil {
+		namespaces = expected.GetNamespaceManager()
+	}
+	changed, err := testing.WriteEntities(filepath.Join(tr.Manifest.ProjectRoot, path), entities.GetEntities(), namespaces)
+	if err != nil {
+		result.SetError(testing.PhaseSnapshot, fmt.Errorf("failed to update expected output %s: %w", path, err))
+		return false
+	}
+	if changed {
+		result.UpdatedSnapshots = append(result.UpdatedSnapshots, path)
+	}
+	return true
 }
 
 func (tr *TestRunner) DetermineRequiredDatasets(testId string, includeCommon bool) ([]*testing.StoredDataset, error) {
@@ -187,16 +809,23 @@ func (tr *TestRunner) DetermineRequiredDatasets(testId string, includeCommon boo
 		for _, newDataset := range usedDatasets {
 			tr.Manifest.GetTest(testId).AddRequiredDataset(newDataset)
 		}
-		diffs, success = tr.runTests(testId)
+		suite := tr.runTests(testId)
+		success = suite.Success()
+		diffs = suite.Diffs()
 		// Check if diff is only additional entities
 		if !success && len(diffs) > 0 {
 			onlyExtra := 0
+			counted := 0
 			for _, diff := range diffs {
+				if diff.Ignored() {
+					continue
+				}
+				counted++
 				if diff.Type == "extra" && diff.ValueType == "entity" {
 					onlyExtra++
 				}
 			}
-			if onlyExtra == len(diffs) {
+			if onlyExtra == counted {
 				success = true
 				log.Printf("Only additional entities found in diff. No more required datasets")
 			}
@@ -212,17 +841,3 @@ func (tr *TestRunner) DetermineRequiredDatasets(testId string, includeCommon boo
 	}
 	return usedDatasets, nil
 }
-
-func logDiffs(diffs []testing.Diff, label string) {
-	for _, diff := range diffs {
-		caser := cases.Title(language.English)
-		log.Printf("%s - %s: Key: %s ExpectedValue: %s ResultValue: %s ValueType: %s",
-			label,
-			caser.String(diff.Type),
-			diff.Key,
-			diff.ExpectedValue,
-			diff.ResultValue,
-			diff.ValueType)
-	}
-
-}
@@ -0,0 +1,424 @@
+package datahub_job_testing
+
+import (
+	"errors"
+	"github.com/mimiro-io/datahub-job-testing/reports"
+	"github.com/mimiro-io/datahub-job-testing/testing"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	gotesting "testing"
+)
+
+// newRunner copies the project in testdata/project to a temporary git repo with the given manifest, and returns a
+// runner for it without reporters
+func newRunner(t *gotesting.T, manifest string) *TestRunner {
+	t.Helper()
+	root := t.TempDir()
+	err := filepath.WalkDir("testdata/project", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, path[len("testdata/project"):])
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "-C", root, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("failed to init repo: %s %s", err, out)
+	}
+	path := filepath.Join(root, "manifest.json")
+	if err := os.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTestRunner(path)
+	tr.Reporters = nil
+	return tr
+}
+
+const runManifest = `{
+  "tests": [
+    {
+      "id": "ok",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutput": "tests/expected/out.json"
+    },
+    {
+      "id": "bad",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutput": "tests/expected/bad.json"
+    }
+  ]
+}`
+
+func TestRunSingleTest(t *gotesting.T) {
+	tr := newRunner(t, runManifest)
+	tests := []struct {
+		id     string
+		status testing.Status
+		error  string
+	}{
+		{"ok", testing.StatusPassed, ""},
+		{"bad", testing.StatusFailed, ""},
+		{"missing", testing.StatusError, "no test found with id missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.id, func(t *gotesting.T) {
+			suite := tr.RunSingleTest(test.id)
+			if len(suite.Tests) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(suite.Tests))
+			}
+			result := suite.Tests[0]
+			if result.Id != test.id || result.Status != test.status || result.Error != test.error {
+				t.Errorf("expected %s to be %s with error %q, got %s with error %q", test.id, test.status, test.error, result.Status, result.Error)
+			}
+			if suite.Success() != (test.status == testing.StatusPassed) {
+				t.Errorf("expected success %v", test.status == testing.StatusPassed)
+			}
+		})
+	}
+}
+
+func TestRunAllTestsParallel(t *gotesting.T) {
+	suite := newRunner(t, runManifest).WithParallelism(2).RunAllTests()
+	var statuses []testing.Status
+	for _, result := range suite.Tests {
+		statuses = append(statuses, result.Status)
+	}
+	if len(statuses) != 2 || statuses[0] != testing.StatusPassed || statuses[1] != testing.StatusFailed {
+		t.Errorf("expected ok to pass and bad to fail in manifest order, got %v", statuses)
+	}
+}
+
+// failingReporter fails to write its report when the suite is finished
+type failingReporter struct{}
+
+func (r failingReporter) SuiteStarted(tests []*testing.Test) {}
+
+func (r failingReporter) TestStarted(test *testing.Test) {}
+
+func (r failingReporter) TestFinished(result *testing.TestResult) {}
+
+func (r failingReporter) SuiteFinished(suite *testing.SuiteResult) error {
+	return errors.New("disk full")
+}
+
+func TestReportError(t *gotesting.T) {
+	tr := newRunner(t, runManifest).AddReporter(failingReporter{}).AddReporter(reports.NewJSONReporter(io.Discard))
+	for _, id := range []string{"ok", "missing"} {
+		suite := tr.RunSingleTest(id)
+		if suite.ReportError == nil || suite.ReportError.Error() != "disk full" {
+			t.Errorf("expected the report error of %s, got %v", id, suite.ReportError)
+		}
+	}
+}
+
+func TestRunDiffsByTest(t *gotesting.T) {
+	suite := newRunner(t, runManifest).RunAllTests()
+	byTest := suite.DiffsByTest()
+	if len(byTest) != 1 || len(byTest["bad"]) == 0 || len(suite.Tests[0].Diffs) != 0 {
+		t.Fatalf("expected diffs for bad only, got %v", byTest)
+	}
+	entities := map[string]bool{}
+	for _, diff := range byTest["bad"] {
+		if diff.TestId != "bad" {
+			t.Errorf("expected the diff to belong to bad, got %s", diff.TestId)
+		}
+		entities[diff.EntityId] = true
+	}
+	for _, id := range []string{"http://data.example.io/src/1", "http://data.example.io/src/2", "http://data.example.io/src/3"} {
+		if !entities[id] {
+			t.Errorf("expected a diff for entity %s, got %v", id, entities)
+		}
+	}
+	if len(suite.Diffs()) != len(byTest["bad"]) {
+		t.Errorf("expected all diffs of the suite to belong to bad")
+	}
+}
+
+func TestRunPhases(t *gotesting.T) {
+	tr := newRunner(t, `{
+  "tests": [
+    {
+      "id": "phases",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "phases": [
+        {"expectedOutput": "tests/expected/out.json", "run": "fullsync"},
+        {
+          "name": "changed",
+          "datasets": [{"name": "src", "path": "tests/phases/src2.json"}],
+          "expectedOutput": "tests/phases/out2.json",
+          "expectedChanges": "tests/phases/changes2.json"
+        }
+      ]
+    },
+    {
+      "id": "stale",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "phases": [
+        {"expectedOutput": "tests/expected/out.json"},
+        {
+          "name": "changed",
+          "datasets": [{"name": "src", "path": "tests/phases/src2.json"}],
+          "expectedChanges": "tests/expected/out.json"
+        }
+      ]
+    }
+  ]
+}`)
+	suite := tr.RunAllTests()
+	if phases := suite.Tests[0]; phases.Status != testing.StatusPassed {
+		t.Errorf("expected phases to pass, got %s %s %v", phases.Status, phases.Error, phases.Diffs)
+	}
+	stale := suite.Tests[1]
+	if stale.Status != testing.StatusFailed || len(stale.Diffs) == 0 {
+		t.Fatalf("expected stale to fail, got %s %s", stale.Status, stale.Error)
+	}
+	for _, diff := range stale.Diffs {
+		if diff.Assertion != "changed changes" {
+			t.Errorf("expected only diffs of the changes in the second phase, got %s diff of %s", diff.Type, diff.Assertion)
+		}
+	}
+}
+
+func TestRunSteps(t *gotesting.T) {
+	tr := newRunner(t, `{
+  "tests": [
+    {
+      "id": "steps",
+      "jobPath": "jobs/job1.json",
+      "steps": [
+        {"type": "upload", "dataset": "src", "path": "tests/data/src.json"},
+        {"type": "run"},
+        {"name": "born", "type": "assertDataset", "expected": "tests/expected/out.json"},
+        {"type": "upload", "dataset": "src", "path": "tests/phases/src2.json"},
+        {"type": "run", "mode": "incremental"},
+        {"name": "moved", "type": "assertChanges", "expected": "tests/steps/changes.json"},
+        {"type": "delete", "dataset": "src", "ids": ["s:1"]},
+        {"type": "run"},
+        {"type": "assertDataset", "dataset": "src", "expected": "tests/steps/src-deleted.json"},
+        {"name": "slaughtered", "type": "assertChanges", "expected": "tests/steps/changes-deleted.json"}
+      ]
+    },
+    {
+      "id": "unknown-step",
+      "jobPath": "jobs/job1.json",
+      "steps": [{"type": "run"}, {"type": "wait"}]
+    },
+    {
+      "id": "wrong-step",
+      "jobPath": "jobs/job1.json",
+      "steps": [
+        {"type": "upload", "dataset": "src", "path": "tests/data/src.json"},
+        {"type": "run"},
+        {"name": "born", "type": "assertDataset", "expected": "tests/expected/bad.json"},
+        {"name": "unchanged", "type": "assertDataset", "dataset": "src", "expected": "tests/data/src.json"}
+      ]
+    }
+  ],
+  "namespaces": {"s": "http://data.example.io/src/"}
+}`)
+	suite := tr.RunAllTests()
+	steps, unknown, wrong := suite.Tests[0], suite.Tests[1], suite.Tests[2]
+	if steps.Status != testing.StatusPassed {
+		t.Errorf("expected steps to pass, got %s %s %v", steps.Status, steps.Error, steps.Diffs)
+	}
+	if unknown.Status != testing.StatusError || unknown.Phase != testing.PhaseSetup || unknown.Error != "step 2 (wait): unknown step type 'wait'" {
+		t.Errorf("expected a setup error for the unknown step, got %s %s %s", unknown.Status, unknown.Phase, unknown.Error)
+	}
+	if wrong.Status != testing.StatusFailed || len(wrong.Diffs) == 0 {
+		t.Fatalf("expected wrong-step to fail, got %s %s", wrong.Status, wrong.Error)
+	}
+	for _, diff := range wrong.Diffs {
+		if diff.Assertion != "born" {
+			t.Errorf("expected only diffs of the born step, got %s diff of %s", diff.Type, diff.Assertion)
+		}
+	}
+}
+
+func TestRunExpectedOutputs(t *gotesting.T) {
+	tr := newRunner(t, `{
+  "tests": [
+    {
+      "id": "helper",
+      "jobPath": "jobs/job3.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutput": "tests/expected/out.json",
+      "expectedOutputs": {"log": "tests/expected/log.json"},
+      "unchangedDatasets": ["src"]
+    },
+    {
+      "id": "wrong-log",
+      "jobPath": "jobs/job3.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutputs": {"out": "tests/expected/out.json", "log": "tests/expected/bad.json"}
+    }
+  ]
+}`)
+	suite := tr.RunAllTests()
+	helper, wrongLog := suite.Tests[0], suite.Tests[1]
+	if helper.Status != testing.StatusPassed || helper.ExpectedEntities != 4 || helper.ResultEntities != 4 {
+		t.Errorf("expected helper to pass with 4 expected and result entities, got %s with %d and %d: %s",
+			helper.Status, helper.ExpectedEntities, helper.ResultEntities, helper.Error)
+	}
+	if wrongLog.Status != testing.StatusFailed || len(wrongLog.Diffs) == 0 {
+		t.Fatalf("expected wrong-log to fail with diffs, got %s", wrongLog.Status)
+	}
+	for _, diff := range wrongLog.Diffs {
+		if diff.Assertion != "log" {
+			t.Errorf("expected only diffs of the log dataset, got %s diff of %s", diff.Type, diff.Assertion)
+		}
+	}
+}
+
+func TestRunReuseDatahub(t *gotesting.T) {
+	tr := newRunner(t, `{
+  "tests": [
+    {
+      "id": "first",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "phases": [{"expectedOutput": "tests/expected/out.json"}]
+    },
+    {
+      "id": "extra-entity",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/phases/src2.json"}],
+      "expectedOutput": "tests/expected/bad.json"
+    },
+    {
+      "id": "again",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "phases": [{"expectedOutput": "tests/expected/out.json"}]
+    },
+    {
+      "id": "isolated",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutput": "tests/expected/out.json",
+      "isolated": true
+    }
+  ]
+}`).WithReuseDatahub(true)
+	suite := tr.RunAllTests()
+	expected := []testing.Status{testing.StatusPassed, testing.StatusFailed, testing.StatusPassed, testing.StatusPassed}
+	for i, result := range suite.Tests {
+		if result.Status != expected[i] {
+			t.Errorf("expected %s to be %s, got %s %s %v", result.Id, expected[i], result.Status, result.Error, result.Diffs)
+		}
+	}
+}
+
+func TestRunKeepOnFailure(t *gotesting.T) {
+	var kept *testing.DatahubManager
+	var datasets []string
+	wait := waitForInterrupt
+	defer func() { waitForInterrupt = wait }()
+
+	tr := newRunner(t, runManifest).WithKeepOnFailure(true)
+	tr.Manifest.Tests[0], tr.Manifest.Tests[1] = tr.Manifest.Tests[1], tr.Manifest.Tests[0]
+	waitForInterrupt = func() {
+		kept = tr.kept.manager
+		datasets, _ = kept.Datasets(tr.kept.client)
+	}
+	suite := tr.RunAllTests()
+	bad, ok := suite.Tests[0], suite.Tests[1]
+	if bad.Status != testing.StatusFailed || ok.Status != testing.StatusSkipped || ok.Error != "stopped after test bad failed" {
+		t.Errorf("expected bad to fail and ok to be skipped, got %s and %s %s", bad.Status, ok.Status, ok.Error)
+	}
+	if kept == nil || strings.Join(datasets, ",") != "out,src" {
+		t.Fatalf("expected the datahub of bad to be kept with its datasets, got %v", datasets)
+	}
+	if _, err := os.Stat(kept.Location); !os.IsNotExist(err) {
+		t.Errorf("expected the store of the kept datahub to be removed after the interrupt, got %v", err)
+	}
+
+	// a new run starts over, and a test failing before its datahub starts keeps nothing
+	kept = nil
+	tr.Manifest.Tests[0].CompareMode = "fuzzy"
+	suite = tr.RunAllTests()
+	bad, ok = suite.Tests[0], suite.Tests[1]
+	if bad.Status != testing.StatusError || ok.Status != testing.StatusSkipped || kept != nil {
+		t.Errorf("expected bad to error and ok to be skipped without keeping a datahub, got %s and %s", bad.Status, ok.Status)
+	}
+	if suite := tr.RunSingleTest("ok"); suite.Tests[0].Status != testing.StatusPassed {
+		t.Errorf("expected ok to run after the failed run, got %s", suite.Tests[0].Status)
+	}
+}
+
+func TestRunLogs(t *gotesting.T) {
+	tests := []struct {
+		level      zapcore.Level
+		belowLevel bool
+	}{
+		{zapcore.DebugLevel, true},
+		{zapcore.ErrorLevel, false},
+	}
+	for _, test := range tests {
+		t.Run(test.level.String(), func(t *gotesting.T) {
+			suite := newRunner(t, runManifest).WithLogLevel(test.level).RunSingleTest("ok")
+			result := suite.Tests[0]
+			if len(result.TransformLogs) != 2 || result.TransformLogs[0].Level != "warn" {
+				t.Errorf("expected the 2 warnings of the transform at all levels, got %v", result.TransformLogs)
+			}
+			belowLevel := false
+			for _, entry := range result.Logs {
+				var level zapcore.Level
+				level.UnmarshalText([]byte(entry.Level))
+				belowLevel = belowLevel || level < zapcore.ErrorLevel
+			}
+			if belowLevel != test.belowLevel {
+				t.Errorf("expected messages below error level to be kept: %v, got %v", test.belowLevel, result.Logs)
+			}
+		})
+	}
+}
+
+func TestRunExpectedLogs(t *gotesting.T) {
+	tr := newRunner(t, `{
+  "tests": [
+    {
+      "id": "logs",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutput": "tests/expected/out.json",
+      "expectedLogs": [{"level": "error", "count": 0}, {"level": "warn", "match": "transformed .*1$"}]
+    },
+    {
+      "id": "too-many",
+      "jobPath": "jobs/job1.json",
+      "requiredDatasets": [{"name": "src", "path": "tests/data/src.json"}],
+      "expectedOutput": "tests/expected/out.json",
+      "expectedLogs": [{"level": "warning", "match": "transformed", "count": 1}]
+    }
+  ]
+}`)
+	suite := tr.RunAllTests()
+	if logs := suite.Tests[0]; logs.Status != testing.StatusPassed {
+		t.Errorf("expected logs to pass, got %s %s %v", logs.Status, logs.Error, logs.Diffs)
+	}
+	tooMany := suite.Tests[1]
+	if tooMany.Status != testing.StatusFailed || len(tooMany.Diffs) != 1 {
+		t.Fatalf("expected too-many to fail with one diff, got %s %v", tooMany.Status, tooMany.Diffs)
+	}
+	if diff := tooMany.Diffs[0]; diff.Type != "logs" || diff.Assertion != "logs" || diff.ResultValue != 2 {
+		t.Errorf("expected a logs diff with 2 matching messages, got %+v", diff)
+	}
+}
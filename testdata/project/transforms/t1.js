@@ -0,0 +1,14 @@
+export function transform_entities(entities) {
+    const src = GetNamespacePrefix("http://data.example.io/src/");
+    const o = AssertNamespacePrefix("http://data.example.io/out/");
+    const out = [];
+    for (const e of entities) {
+        const n = NewEntity();
+        SetId(n, GetId(e));
+        SetProperty(n, o, "name", GetProperty(e, src, "name"));
+        AddReference(n, o, "type", o + ":Thing");
+        Log("transformed " + GetId(e), "warn");
+        out.push(n);
+    }
+    return out;
+}
@@ -0,0 +1,21 @@
+export function transform_entities(entities) {
+    const src = GetNamespacePrefix("http://data.example.io/src/");
+    const o = AssertNamespacePrefix("http://data.example.io/out/");
+    const out = [];
+    const txn = NewTransaction();
+    const logs = [];
+    for (const e of entities) {
+        const n = NewEntity();
+        SetId(n, GetId(e));
+        SetProperty(n, o, "name", GetProperty(e, src, "name"));
+        AddReference(n, o, "type", o + ":Thing");
+        out.push(n);
+        const l = NewEntity();
+        SetId(l, o + ":log-" + GetProperty(e, src, "name"));
+        SetProperty(l, o, "seen", true);
+        logs.push(l);
+    }
+    txn.DatasetEntities["log"] = logs;
+    ExecuteTransaction(txn);
+    return out;
+}
@@ -0,0 +1,619 @@
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"log"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Diff struct {
+	Type            string `json:"type"`                // missing, diff, extra, duplicate, versions or ignored
+	TestId          string `json:"testId,omitempty"`    // id of the test the diff belongs to
+	EntityId        string `json:"entityId,omitempty"`  // id of the entity the diff belongs to
+	Version         int    `json:"version,omitempty"`   // version of the entity in a changes feed, counted from 1
+	Assertion       string `json:"assertion,omitempty"` // expected output the diff belongs to, for tests with several
+	Key             string `json:"key"`
+	ExpectedValue   any    `json:"expectedValue"`
+	ResultValue     any    `json:"resultValue"`
+	ValueType       string `json:"valueType"`                 // prop, ref, entity, deleted or internalId
+	MissingElements []any  `json:"missingElements,omitempty"` // list elements expected but not found in the result
+	ExtraElements   []any  `json:"extraElements,omitempty"`   // list elements in the result that were not expected
+	Rule            string `json:"rule,omitempty"`            // comparison rule applied to the values, if not exact equality
+	Message         string `json:"message,omitempty"`         // explanation of why the rule did not match
+}
+
+func (d Diff) String() string {
+	return d.Render(nil)
+}
+
+// Render formats the diff with keys, entity ids and reference values as prefixed identifiers where a namespace applies
+func (d Diff) Render(namespaces Namespaces) string {
+	caser := cases.Title(language.English)
+	expectedValue, resultValue := d.ExpectedValue, d.ResultValue
+	missingElements, extraElements := any(d.MissingElements), any(d.ExtraElements)
+	if d.ValueType == "ref" {
+		expectedValue = namespaces.prefixedValue(expectedValue)
+		resultValue = namespaces.prefixedValue(resultValue)
+		missingElements = namespaces.prefixedValue(missingElements)
+		extraElements = namespaces.prefixedValue(extraElements)
+	}
+	s := fmt.Sprintf("%s: Key: %s ExpectedValue: %v ResultValue: %v ValueType: %s",
+		caser.String(d.Type),
+		namespaces.Prefixed(d.Key),
+		expectedValue,
+		resultValue,
+		d.ValueType)
+	if len(d.MissingElements) > 0 {
+		s += fmt.Sprintf(" MissingElements: %v", missingElements)
+	}
+	if len(d.ExtraElements) > 0 {
+		s += fmt.Sprintf(" ExtraElements: %v", extraElements)
+	}
+	if d.Version > 0 {
+		s += fmt.Sprintf(" Version: %d", d.Version)
+	}
+	if d.Rule != "" {
+		s += fmt.Sprintf(" Rule: %s", d.Rule)
+	}
+	if d.Message != "" {
+		s += fmt.Sprintf(" Message: %s", d.Message)
+	}
+	return s
+}
+
+// Ignored returns true for diffs that are only reported in verbose mode and do not fail a test
+func (d Diff) Ignored() bool {
+	return d.Type == "ignored"
+}
+
+// IgnoreRule excludes a property or reference from entity comparison
+type IgnoreRule struct {
+	Key           string `json:"key"`                     // full URI of the property or reference
+	ValueType     string `json:"type,omitempty"`          // prop or ref, both if empty
+	EntityPattern string `json:"entityPattern,omitempty"` // regular expression matched against the full entity id, all entities if empty
+}
+
+// Validate returns an error if the rule has no key, an unknown value type or an invalid entity pattern
+func (r *IgnoreRule) Validate() error {
+	if r.Key == "" {
+		return fmt.Errorf("ignore rule without key")
+	}
+	switch r.ValueType {
+	case "", "prop", "ref":
+	default:
+		return fmt.Errorf("unknown type '%s' in ignore rule for %s", r.ValueType, r.Key)
+	}
+	if _, err := regexp.Compile(r.EntityPattern); err != nil {
+		return fmt.Errorf("invalid entity pattern '%s' in ignore rule for %s: %w", r.EntityPattern, r.Key, err)
+	}
+	return nil
+}
+
+// NumericComparison compares numbers by value instead of by type and exact equality.
+// Setting any of the options enables numeric comparison.
+type NumericComparison struct {
+	AbsTolerance  float64 `json:"absTolerance,omitempty"`  // maximum absolute difference between numbers
+	RelTolerance  float64 `json:"relTolerance,omitempty"`  // maximum difference relative to the larger of the numbers
+	IntFloatEqual bool    `json:"intFloatEqual,omitempty"` // compare ints and floats by value, so 1 equals 1.0
+	CoerceStrings bool    `json:"coerceStrings,omitempty"` // parse strings that look like numbers before comparing
+}
+
+func (n *NumericComparison) enabled() bool {
+	return n != nil && (n.AbsTolerance > 0 || n.RelTolerance > 0 || n.IntFloatEqual || n.CoerceStrings)
+}
+
+func (n *NumericComparison) String() string {
+	rule := fmt.Sprintf("numeric(abs=%g, rel=%g", n.AbsTolerance, n.RelTolerance)
+	if n.IntFloatEqual {
+		rule += ", intFloatEqual"
+	}
+	if n.CoerceStrings {
+		rule += ", coerceStrings"
+	}
+	return rule + ")"
+}
+
+// applies returns true if any of the values, or their list elements, can be compared as a number
+func (n *NumericComparison) applies(values ...any) bool {
+	for _, value := range values {
+		if list, isList := asList(value); isList {
+			if n.applies(list...) {
+				return true
+			}
+		} else if _, ok := toNumber(value, n.CoerceStrings); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// equal returns true if both values are numbers within tolerance. An int and a float are only equal with
+// IntFloatEqual. The second return value is false if the values could not be compared as numbers.
+func (n *NumericComparison) equal(expected, result any) (bool, bool) {
+	e, ok := toNumber(expected, n.CoerceStrings)
+	if !ok {
+		return false, false
+	}
+	r, ok := toNumber(result, n.CoerceStrings)
+	if !ok {
+		return false, false
+	}
+	if !n.IntFloatEqual {
+		expectedKind, resultKind := numberKind(expected), numberKind(result)
+		if expectedKind != "" && resultKind != "" && expectedKind != resultKind {
+			return false, true
+		}
+	}
+	delta := math.Abs(e - r)
+	if delta == 0 || delta <= n.AbsTolerance {
+		return true, true
+	}
+	return delta <= n.RelTolerance*math.Max(math.Abs(e), math.Abs(r)), true
+}
+
+// numberKind returns int or float for numbers, or an empty string for strings coerced to numbers
+func numberKind(value any) string {
+	switch v := value.(type) {
+	case int, int32, int64, uint64:
+		return "int"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "int"
+		}
+		return "float"
+	case string:
+		return ""
+	default:
+		return "float"
+	}
+}
+
+func toNumber(value any, coerceStrings bool) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		if !coerceStrings {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+type CompareMode string
+
+const (
+	// CompareExact requires the result to contain exactly the expected entities, properties and references
+	CompareExact CompareMode = "exact"
+	// CompareSubset requires each expected entity to exist in the result with the listed properties and references.
+	// Extra entities, properties and references in the result are allowed.
+	CompareSubset CompareMode = "subset"
+)
+
+// CompareOptions configures how expected and result entities are compared
+type CompareOptions struct {
+	Mode           CompareMode // defaults to CompareExact
+	Ignore         []*IgnoreRule
+	UnorderedLists bool     // compare all list values as multisets, ignoring element order
+	UnorderedKeys  []string // full URIs of properties and references with list values compared as multisets
+	Numeric        *NumericComparison
+	Verbose        bool // include diffs for ignored keys in the result
+}
+
+type compiledIgnoreRule struct {
+	rule    *IgnoreRule
+	pattern *regexp.Regexp
+}
+
+// comparer holds the prepared options for a single CompareEntities call
+type comparer struct {
+	options   *CompareOptions
+	ignore    []compiledIgnoreRule
+	unordered map[string]bool
+}
+
+func newComparer(options *CompareOptions) *comparer {
+	if options == nil {
+		options = &CompareOptions{}
+	}
+	c := &comparer{options: options, unordered: map[string]bool{}}
+	for _, key := range options.UnorderedKeys {
+		c.unordered[key] = true
+	}
+	for _, rule := range options.Ignore {
+		compiled := compiledIgnoreRule{rule: rule}
+		if rule.EntityPattern != "" {
+			pattern, err := regexp.Compile(rule.EntityPattern)
+			if err != nil {
+				// the test runner rejects invalid rules before comparing, see IgnoreRule.Validate
+				log.Printf("invalid entity pattern '%s' in ignore rule for %s: %s", rule.EntityPattern, rule.Key, err)
+				continue
+			}
+			compiled.pattern = pattern
+		}
+		c.ignore = append(c.ignore, compiled)
+	}
+	return c
+}
+
+// isIgnored returns true if the key of the given value type is ignored for the entity
+func (c *comparer) isIgnored(entityId string, key string, valueType string) bool {
+	for _, compiled := range c.ignore {
+		if compiled.rule.Key != key {
+			continue
+		}
+		if compiled.rule.ValueType != "" && compiled.rule.ValueType != valueType {
+			continue
+		}
+		if compiled.pattern != nil && !compiled.pattern.MatchString(entityId) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// CompareEntities compares two EntityCollections and returns true if they are equal.
+// options may be nil to compare all properties and references.
+// Entities are matched by id, entity ids occurring more than once in a collection are reported as duplicate
+// and compared using their last occurrence.
+func CompareEntities(expected *egdm.EntityCollection, result *egdm.EntityCollection, options *CompareOptions) (bool, []Diff) {
+	c := newComparer(options)
+	// strip recorded
+	expected = stripRecorded(expected)
+	result = stripRecorded(result)
+	var diffs []Diff
+
+	expectedIndex, expectedCounts := indexEntities(expected.Entities)
+	resultIndex, resultCounts := indexEntities(result.Entities)
+
+	for _, id := range uniqueIds(expected.Entities) {
+		expectedEntity := expectedIndex[id]
+		if expectedCounts[id] > 1 || resultCounts[id] > 1 {
+			diffs = append(diffs, duplicateDiff(id, expectedCounts[id], resultCounts[id]))
+		}
+		resultEntity, found := resultIndex[id]
+		if !found {
+			diffs = append(diffs, Diff{
+				Type:          "missing",
+				EntityId:      id,
+				Key:           expectedEntity.ID,
+				ExpectedValue: "N/A",
+				ResultValue:   "N/A",
+				ValueType:     "entity",
+			})
+			continue
+		}
+		diffs = append(diffs, c.compareEntity(expectedEntity, resultEntity)...)
+	}
+	for _, id := range uniqueIds(result.Entities) {
+		if _, found := expectedIndex[id]; found {
+			continue
+		}
+		if resultCounts[id] > 1 {
+			diffs = append(diffs, duplicateDiff(id, 0, resultCounts[id]))
+		}
+		diffs = c.appendExtra(diffs, id, Diff{
+			Type:          "extra",
+			EntityId:      id,
+			Key:           id,
+			ExpectedValue: "N/A",
+			ResultValue:   "N/A",
+			ValueType:     "entity",
+		})
+	}
+
+	return equalDiffs(diffs), diffs
+}
+
+// equalDiffs returns true if all diffs are ignored
+func equalDiffs(diffs []Diff) bool {
+	for _, diff := range diffs {
+		if !diff.Ignored() {
+			return false
+		}
+	}
+	return true
+}
+
+// compareEntity returns the diffs in properties, references and entity level fields of two entities with the same id
+func (c *comparer) compareEntity(expected, result *egdm.Entity) []Diff {
+	var diffs []Diff
+	if !reflect.DeepEqual(expected.Properties, result.Properties) {
+		diffs = append(diffs, c.findMapDiff(expected.ID, expected.Properties, result.Properties, "prop")...)
+	}
+	if !reflect.DeepEqual(expected.References, result.References) {
+		diffs = append(diffs, c.findMapDiff(expected.ID, expected.References, result.References, "ref")...)
+	}
+	return append(diffs, c.findEntityDiff(expected, result)...)
+}
+
+// indexEntities returns the last occurrence of each entity id, and the number of occurrences per id
+func indexEntities(entities []*egdm.Entity) (map[string]*egdm.Entity, map[string]int) {
+	index := make(map[string]*egdm.Entity, len(entities))
+	counts := make(map[string]int, len(entities))
+	for _, entity := range entities {
+		index[entity.ID] = entity
+		counts[entity.ID]++
+	}
+	return index, counts
+}
+
+// uniqueIds returns the entity ids in order of first occurrence
+func uniqueIds(entities []*egdm.Entity) []string {
+	seen := make(map[string]bool, len(entities))
+	var ids []string
+	for _, entity := range entities {
+		if !seen[entity.ID] {
+			seen[entity.ID] = true
+			ids = append(ids, entity.ID)
+		}
+	}
+	return ids
+}
+
+// duplicateDiff reports an entity id occurring more than once, with the number of occurrences as values
+func duplicateDiff(id string, expectedCount, resultCount int) Diff {
+	return Diff{
+		Type:          "duplicate",
+		EntityId:      id,
+		Key:           id,
+		ExpectedValue: expectedCount,
+		ResultValue:   resultCount,
+		ValueType:     "entity",
+	}
+}
+
+// stripRecorded set recorded timestamp to 0 on all entities
+// in the given EntityCollection to avoid false positives on entity comparison
+func stripRecorded(collection *egdm.EntityCollection) *egdm.EntityCollection {
+	for _, entity := range collection.Entities {
+		entity.Recorded = 0
+	}
+	return collection
+}
+
+// findEntityDiff finds diffs in entity level fields other than properties and references.
+// The recorded timestamp is never compared, the internal id only if set in the expected entity.
+func (c *comparer) findEntityDiff(expected, result *egdm.Entity) []Diff {
+	var diffs []Diff
+	if expected.IsDeleted != result.IsDeleted {
+		diffs = append(diffs, Diff{
+			Type:          "diff",
+			EntityId:      expected.ID,
+			Key:           expected.ID,
+			ExpectedValue: expected.IsDeleted,
+			ResultValue:   result.IsDeleted,
+			ValueType:     "deleted",
+		})
+	}
+	if expected.InternalID != 0 && expected.InternalID != result.InternalID {
+		diffs = append(diffs, Diff{
+			Type:          "diff",
+			EntityId:      expected.ID,
+			Key:           expected.ID,
+			ExpectedValue: expected.InternalID,
+			ResultValue:   result.InternalID,
+			ValueType:     "internalId",
+		})
+	}
+	return diffs
+}
+
+// findMapDiff finds the diff between an entity's prop or ref map and returns a []Diff slice.
+// Ignored keys are only included in verbose mode.
+func (c *comparer) findMapDiff(entityId string, expected, result map[string]any, valueType string) []Diff {
+	var diffs []Diff
+	for _, key := range sortedKeys(expected) {
+		val := expected[key]
+		val2, exist := result[key]
+		if !exist {
+			// Missing in result
+			diffs = c.appendDiff(diffs, entityId, Diff{
+				Type:          "missing",
+				Key:           key,
+				ExpectedValue: val,
+				ResultValue:   nil,
+				ValueType:     valueType,
+			})
+		} else if !c.valuesEqual(key, val, val2) {
+			// Different value in result
+			diff := Diff{
+				Type:          "diff",
+				Key:           key,
+				ExpectedValue: val,
+				ResultValue:   val2,
+				ValueType:     valueType,
+			}
+			expectedList, isList := asList(val)
+			resultList, isResultList := asList(val2)
+			if isList && isResultList {
+				diff.MissingElements, diff.ExtraElements = c.listDiff(key, expectedList, resultList)
+			}
+			if isMatcher(val) {
+				diff.Rule = val.(string)
+				_, diff.Message = match(val.(string), val2)
+			} else if c.options.Numeric.enabled() && c.options.Numeric.applies(val, val2) {
+				diff.Rule = c.options.Numeric.String()
+			}
+			diffs = c.appendDiff(diffs, entityId, diff)
+		}
+	}
+
+	for _, key := range sortedKeys(result) {
+		val := result[key]
+		_, exist := expected[key]
+		if !exist {
+			// Extra in result
+			diffs = c.appendExtra(diffs, entityId, Diff{
+				Type:          "extra",
+				Key:           key,
+				ExpectedValue: "N/A",
+				ResultValue:   val,
+				ValueType:     valueType,
+			})
+		}
+	}
+	return diffs
+}
+
+// sortedKeys returns the keys of a prop or ref map in sorted order, so diffs are listed in a stable order
+func sortedKeys(values map[string]any) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valuesEqual compares an expected and a result value of the given property or reference key
+func (c *comparer) valuesEqual(key string, expected, result any) bool {
+	if isMatcher(expected) {
+		matched, _ := match(expected.(string), result)
+		return matched
+	}
+	if c.options.UnorderedLists || c.unordered[key] {
+		expectedList, isList := asList(expected)
+		resultList, isResultList := asList(result)
+		if isList && isResultList {
+			missing, extra := c.listDiff(key, expectedList, resultList)
+			return len(missing) == 0 && len(extra) == 0
+		}
+	}
+	if reflect.DeepEqual(expected, result) {
+		return true
+	}
+	if c.options.Numeric.enabled() {
+		if equal, numeric := c.options.Numeric.equal(expected, result); numeric {
+			return equal
+		}
+	}
+	if expectedList, isList := asList(expected); isList {
+		// compare ordered lists element by element, so that numeric rules apply to list elements
+		resultList, isResultList := asList(result)
+		if !isResultList || len(expectedList) != len(resultList) {
+			return false
+		}
+		for i := range expectedList {
+			if !c.valuesEqual(key, expectedList[i], resultList[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// listDiff returns the expected elements missing from the result and the extra elements in the result,
+// treating both lists as multisets. Elements are paired with a maximum bipartite matching, so that with tolerances
+// an element matching several others does not take the only match of another element.
+func (c *comparer) listDiff(key string, expected, result []any) ([]any, []any) {
+	candidates := make([][]int, len(expected))
+	for i, e := range expected {
+		for j, r := range result {
+			if c.valuesEqual(key, e, r) {
+				candidates[i] = append(candidates[i], j)
+			}
+		}
+	}
+	matchedBy := make([]int, len(result)) // index of the expected element matched to each result element, or -1
+	for j := range matchedBy {
+		matchedBy[j] = -1
+	}
+	var missing []any
+	for i, e := range expected {
+		if !augment(i, candidates, matchedBy, make([]bool, len(result))) {
+			missing = append(missing, e)
+		}
+	}
+	var extra []any
+	for j, r := range result {
+		if matchedBy[j] < 0 {
+			extra = append(extra, r)
+		}
+	}
+	return missing, extra
+}
+
+// augment tries to match expected element i, moving earlier matches to other candidates if needed
+func augment(i int, candidates [][]int, matchedBy []int, visited []bool) bool {
+	for _, j := range candidates[i] {
+		if visited[j] {
+			continue
+		}
+		visited[j] = true
+		if matchedBy[j] < 0 || augment(matchedBy[j], candidates, matchedBy, visited) {
+			matchedBy[j] = i
+			return true
+		}
+	}
+	return false
+}
+
+// asList returns the value as []any if it is a list value. Reference lists are parsed as []string.
+func asList(value any) ([]any, bool) {
+	switch v := value.(type) {
+	case []any:
+		return v, true
+	case []string:
+		list := make([]any, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+// appendDiff appends the diff, or drops it if its key is ignored. In verbose mode ignored diffs are kept as type ignored.
+func (c *comparer) appendDiff(diffs []Diff, entityId string, diff Diff) []Diff {
+	diff.EntityId = entityId
+	if !c.isIgnored(entityId, diff.Key, diff.ValueType) {
+		return append(diffs, diff)
+	}
+	return c.appendIgnored(diffs, diff)
+}
+
+// appendExtra appends a diff for an extra entity, property or reference, which is allowed in subset mode
+func (c *comparer) appendExtra(diffs []Diff, entityId string, diff Diff) []Diff {
+	diff.EntityId = entityId
+	if c.options.Mode == CompareSubset {
+		return c.appendIgnored(diffs, diff)
+	}
+	return c.appendDiff(diffs, entityId, diff)
+}
+
+func (c *comparer) appendIgnored(diffs []Diff, diff Diff) []Diff {
+	if c.options.Verbose {
+		diff.Type = "ignored"
+		return append(diffs, diff)
+	}
+	return diffs
+}
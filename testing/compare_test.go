@@ -0,0 +1,405 @@
+package testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	gotesting "testing"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+const (
+	testName = "http://data.mimiro.io/test/name"
+	testType = "http://data.mimiro.io/test/type"
+)
+
+// testEntity returns an entity with the given id, properties and references
+func testEntity(id string, properties map[string]any, references map[string]any) *egdm.Entity {
+	entity := egdm.NewEntity().SetID("http://data.mimiro.io/test/" + id)
+	for key, value := range properties {
+		entity.SetProperty(key, value)
+	}
+	for key, value := range references {
+		entity.SetReference(key, value)
+	}
+	return entity
+}
+
+func testCollection(entities ...*egdm.Entity) *egdm.EntityCollection {
+	ec := egdm.NewEntityCollection(nil)
+	for _, entity := range entities {
+		ec.AddEntity(entity)
+	}
+	return ec
+}
+
+// diffSummary returns the type, value type and key of each diff, like "diff prop http://data.mimiro.io/test/name"
+func diffSummary(diffs []Diff) []string {
+	var summary []string
+	for _, diff := range diffs {
+		summary = append(summary, diff.Type+" "+diff.ValueType+" "+diff.Key)
+	}
+	sort.Strings(summary)
+	return summary
+}
+
+// generateEntities returns a collection of n entities, in reverse order if reversed is true
+func generateEntities(n int, reversed bool) *egdm.EntityCollection {
+	ec := egdm.NewEntityCollection(nil)
+	for i := 0; i < n; i++ {
+		id := i
+		if reversed {
+			id = n - 1 - i
+		}
+		entity := egdm.NewEntity().SetID(fmt.Sprintf("http://data.mimiro.io/test/%d", id))
+		entity.SetProperty("http://data.mimiro.io/test/name", fmt.Sprintf("entity %d", id))
+		entity.SetProperty("http://data.mimiro.io/test/count", float64(id))
+		entity.SetReference("http://data.mimiro.io/test/type", "http://data.mimiro.io/test/Thing")
+		ec.AddEntity(entity)
+	}
+	return ec
+}
+
+func TestCompareEntitiesIgnore(t *gotesting.T) {
+	expected := testCollection(
+		testEntity("1", map[string]any{testName: "one"}, map[string]any{testType: "http://data.mimiro.io/test/A"}),
+		testEntity("2", map[string]any{testName: "two"}, nil),
+	)
+	result := testCollection(
+		testEntity("1", map[string]any{testName: "changed"}, map[string]any{testType: "http://data.mimiro.io/test/B"}),
+		testEntity("2", map[string]any{testName: "changed", testType: "extra"}, nil),
+	)
+	tests := []struct {
+		name     string
+		ignore   []*IgnoreRule
+		verbose  bool
+		expected []string
+	}{
+		{"no rules", nil, false, []string{
+			"diff prop " + testName, "diff prop " + testName, "diff ref " + testType, "extra prop " + testType}},
+		{"key of both types", []*IgnoreRule{{Key: testType}}, false, []string{
+			"diff prop " + testName, "diff prop " + testName}},
+		{"key of one type", []*IgnoreRule{{Key: testType, ValueType: "ref"}}, false, []string{
+			"diff prop " + testName, "diff prop " + testName, "extra prop " + testType}},
+		{"entity pattern", []*IgnoreRule{{Key: testName, EntityPattern: "/1$"}}, false, []string{
+			"diff prop " + testName, "diff ref " + testType, "extra prop " + testType}},
+		{"verbose keeps ignored diffs", []*IgnoreRule{{Key: testName, EntityPattern: "/1$"}}, true, []string{
+			"diff prop " + testName, "diff ref " + testType, "extra prop " + testType, "ignored prop " + testName}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			_, diffs := CompareEntities(expected, result, &CompareOptions{Ignore: test.ignore, Verbose: test.verbose})
+			if summary := diffSummary(diffs); !reflect.DeepEqual(summary, test.expected) {
+				t.Errorf("expected diffs %v, got %v", test.expected, summary)
+			}
+		})
+	}
+}
+
+func TestIgnoreRuleValidate(t *gotesting.T) {
+	tests := []struct {
+		name  string
+		rule  IgnoreRule
+		valid bool
+	}{
+		{"key", IgnoreRule{Key: testName}, true},
+		{"type and pattern", IgnoreRule{Key: testName, ValueType: "prop", EntityPattern: "^http://data.mimiro.io/"}, true},
+		{"no key", IgnoreRule{ValueType: "prop"}, false},
+		{"unknown type", IgnoreRule{Key: testName, ValueType: "property"}, false},
+		{"invalid pattern", IgnoreRule{Key: testName, EntityPattern: "(["}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			if err := test.rule.Validate(); (err == nil) != test.valid {
+				t.Errorf("expected valid %t, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesSubset(t *gotesting.T) {
+	expected := testCollection(testEntity("1", map[string]any{testName: "one"}, nil))
+	tests := []struct {
+		name     string
+		result   *egdm.EntityCollection
+		mode     CompareMode
+		expected []string
+	}{
+		{"extra entity", testCollection(
+			testEntity("1", map[string]any{testName: "one"}, nil),
+			testEntity("2", nil, nil)), CompareSubset, nil},
+		{"extra property and reference", testCollection(
+			testEntity("1", map[string]any{testName: "one", testType: "thing"}, map[string]any{testType: "http://data.mimiro.io/test/A"})), CompareSubset, nil},
+		{"changed property", testCollection(
+			testEntity("1", map[string]any{testName: "changed"}, nil)), CompareSubset, []string{"diff prop " + testName}},
+		{"missing property", testCollection(
+			testEntity("1", nil, nil)), CompareSubset, []string{"missing prop " + testName}},
+		{"missing entity", testCollection(
+			testEntity("2", nil, nil)), CompareSubset, []string{"missing entity http://data.mimiro.io/test/1"}},
+		{"extra entity in exact mode", testCollection(
+			testEntity("1", map[string]any{testName: "one"}, nil),
+			testEntity("2", nil, nil)), CompareExact, []string{"extra entity http://data.mimiro.io/test/2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareEntities(expected, test.result, &CompareOptions{Mode: test.mode})
+			if summary := diffSummary(diffs); !reflect.DeepEqual(summary, test.expected) {
+				t.Errorf("expected diffs %v, got %v", test.expected, summary)
+			}
+			if equal != (len(test.expected) == 0) {
+				t.Errorf("expected equal to be %t", len(test.expected) == 0)
+			}
+		})
+	}
+}
+
+func TestListDiffMatching(t *gotesting.T) {
+	tests := []struct {
+		name     string
+		numeric  *NumericComparison
+		expected []any
+		result   []any
+		missing  int
+		extra    int
+	}{
+		{"equal", nil, []any{1.0, 2.0}, []any{2.0, 1.0}, 0, 0},
+		{"tolerant match taking another element's only match", &NumericComparison{AbsTolerance: 0.05}, []any{1.0, 1.05}, []any{1.04, 0.99}, 0, 0},
+		{"exact before tolerant", &NumericComparison{AbsTolerance: 0.1}, []any{1.0, 1.1}, []any{1.1, 1.0}, 0, 0},
+		{"duplicate elements", nil, []any{1.0, 1.0}, []any{1.0, 2.0}, 1, 1},
+		{"no match", &NumericComparison{AbsTolerance: 0.05}, []any{1.0}, []any{1.5}, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			c := newComparer(&CompareOptions{UnorderedLists: true, Numeric: test.numeric})
+			missing, extra := c.listDiff("http://data.mimiro.io/test/values", test.expected, test.result)
+			if len(missing) != test.missing || len(extra) != test.extra {
+				t.Errorf("expected %d missing and %d extra, got missing %v and extra %v", test.missing, test.extra, missing, extra)
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesUnorderedLists(t *gotesting.T) {
+	const testTags = "http://data.mimiro.io/test/tags"
+	tests := []struct {
+		name          string
+		options       *CompareOptions
+		expected      []any
+		result        []any
+		equal         bool
+		missing       []any
+		extraElements []any
+	}{
+		{"ordered by default", nil, []any{"a", "b"}, []any{"b", "a"}, false, nil, nil},
+		{"all lists", &CompareOptions{UnorderedLists: true}, []any{"a", "b"}, []any{"b", "a"}, true, nil, nil},
+		{"listed key", &CompareOptions{UnorderedKeys: []string{testTags}}, []any{"a", "b"}, []any{"b", "a"}, true, nil, nil},
+		{"other key", &CompareOptions{UnorderedKeys: []string{testName}}, []any{"a", "b"}, []any{"b", "a"}, false, nil, nil},
+		{"multiset counts", &CompareOptions{UnorderedLists: true}, []any{"a", "a", "b"}, []any{"b", "a", "b"}, false, []any{"a"}, []any{"b"}},
+		{"different length", &CompareOptions{UnorderedLists: true}, []any{"a"}, []any{"b", "a"}, false, nil, []any{"b"}},
+		{"tolerant elements", &CompareOptions{UnorderedLists: true, Numeric: &NumericComparison{AbsTolerance: 0.05}},
+			[]any{1.0, 1.05}, []any{1.04, 0.99}, true, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareEntities(
+				testCollection(testEntity("1", map[string]any{testTags: test.expected}, nil)),
+				testCollection(testEntity("1", map[string]any{testTags: test.result}, nil)),
+				test.options)
+			if equal != test.equal {
+				t.Fatalf("expected equal to be %t, got diffs %v", test.equal, diffs)
+			}
+			if equal {
+				return
+			}
+			if len(diffs) != 1 {
+				t.Fatalf("expected 1 diff, got %v", diffs)
+			}
+			if len(diffs[0].MissingElements) != len(test.missing) || len(diffs[0].ExtraElements) != len(test.extraElements) ||
+				(len(test.missing) > 0 && !reflect.DeepEqual(diffs[0].MissingElements, test.missing)) ||
+				(len(test.extraElements) > 0 && !reflect.DeepEqual(diffs[0].ExtraElements, test.extraElements)) {
+				t.Errorf("expected missing %v and extra %v, got missing %v and extra %v",
+					test.missing, test.extraElements, diffs[0].MissingElements, diffs[0].ExtraElements)
+			}
+		})
+	}
+}
+
+func TestNumericComparisonString(t *gotesting.T) {
+	tests := []struct {
+		numeric  NumericComparison
+		expected string
+	}{
+		{NumericComparison{AbsTolerance: 0.5}, "numeric(abs=0.5, rel=0)"},
+		{NumericComparison{RelTolerance: 0.01, IntFloatEqual: true}, "numeric(abs=0, rel=0.01, intFloatEqual)"},
+		{NumericComparison{AbsTolerance: 0.5, IntFloatEqual: true, CoerceStrings: true}, "numeric(abs=0.5, rel=0, intFloatEqual, coerceStrings)"},
+	}
+	for _, test := range tests {
+		if s := test.numeric.String(); s != test.expected {
+			t.Errorf("expected rule %s, got %s", test.expected, s)
+		}
+	}
+}
+
+func TestNumericComparisonEqual(t *gotesting.T) {
+	tests := []struct {
+		name     string
+		numeric  NumericComparison
+		expected any
+		result   any
+		equal    bool
+		numbers  bool
+	}{
+		{"within absolute tolerance", NumericComparison{AbsTolerance: 0.1}, 1.0, 1.05, true, true},
+		{"outside absolute tolerance", NumericComparison{AbsTolerance: 0.1}, 1.0, 1.2, false, true},
+		{"within relative tolerance", NumericComparison{RelTolerance: 0.01}, 1000.0, 1009.0, true, true},
+		{"relative to the larger number", NumericComparison{RelTolerance: 0.1}, 100.0, 110.0, true, true},
+		{"outside relative tolerance", NumericComparison{RelTolerance: 0.01}, 1000.0, 1020.0, false, true},
+		{"int and float", NumericComparison{IntFloatEqual: true}, 1, 1.0, true, true},
+		{"int64 and float", NumericComparison{IntFloatEqual: true}, int64(2), 2.5, false, true},
+		{"json number", NumericComparison{IntFloatEqual: true}, json.Number("3"), 3.0, true, true},
+		{"int and float without intFloatEqual", NumericComparison{AbsTolerance: 0.01}, 1, 1.0, false, true},
+		{"ints within tolerance", NumericComparison{AbsTolerance: 1}, 1, int64(2), true, true},
+		{"json float and float", NumericComparison{AbsTolerance: 0.01}, json.Number("1.5"), 1.5, true, true},
+		{"string without coercion", NumericComparison{IntFloatEqual: true}, "1", 1.0, false, false},
+		{"string with coercion", NumericComparison{CoerceStrings: true}, " 1.50", 1.5, true, true},
+		{"string that is not a number", NumericComparison{CoerceStrings: true}, "one", 1.0, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, numbers := test.numeric.equal(test.expected, test.result)
+			if equal != test.equal || numbers != test.numbers {
+				t.Errorf("expected equal %t and numeric %t, got %t and %t", test.equal, test.numbers, equal, numbers)
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesNumeric(t *gotesting.T) {
+	const testCount = "http://data.mimiro.io/test/count"
+	tests := []struct {
+		name     string
+		numeric  *NumericComparison
+		expected any
+		result   any
+		equal    bool
+		rule     string
+	}{
+		{"int and float are different by default", nil, 1, 1.0, false, ""},
+		{"int and float", &NumericComparison{IntFloatEqual: true}, 1, 1.0, true, ""},
+		{"int and float with tolerance only", &NumericComparison{AbsTolerance: 0.01}, 1, 1.0, false, "numeric(abs=0.01, rel=0)"},
+		{"rule on diff", &NumericComparison{AbsTolerance: 0.1}, 1.0, 2.0, false, "numeric(abs=0.1, rel=0)"},
+		{"list elements in order", &NumericComparison{AbsTolerance: 0.1}, []any{1.0, 2.0}, []any{1.05, 1.95}, true, ""},
+		{"strings are not numbers", &NumericComparison{AbsTolerance: 0.1}, "a", "b", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareEntities(
+				testCollection(testEntity("1", map[string]any{testCount: test.expected}, nil)),
+				testCollection(testEntity("1", map[string]any{testCount: test.result}, nil)),
+				&CompareOptions{Numeric: test.numeric})
+			if equal != test.equal {
+				t.Fatalf("expected equal to be %t, got diffs %v", test.equal, diffs)
+			}
+			if !equal && diffs[0].Rule != test.rule {
+				t.Errorf("expected rule '%s', got '%s'", test.rule, diffs[0].Rule)
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesMetadata(t *gotesting.T) {
+	const id = "http://data.mimiro.io/test/1"
+	tests := []struct {
+		name     string
+		expected func(e *egdm.Entity)
+		result   func(e *egdm.Entity)
+		diffs    []string
+	}{
+		{"equal", func(e *egdm.Entity) {}, func(e *egdm.Entity) {}, nil},
+		{"deleted in result", func(e *egdm.Entity) {}, func(e *egdm.Entity) { e.IsDeleted = true }, []string{"diff deleted " + id}},
+		{"deleted in expected", func(e *egdm.Entity) { e.IsDeleted = true }, func(e *egdm.Entity) {}, []string{"diff deleted " + id}},
+		{"internal id not expected", func(e *egdm.Entity) {}, func(e *egdm.Entity) { e.InternalID = 7 }, nil},
+		{"internal id", func(e *egdm.Entity) { e.InternalID = 7 }, func(e *egdm.Entity) { e.InternalID = 8 }, []string{"diff internalId " + id}},
+		{"recorded is never compared", func(e *egdm.Entity) { e.Recorded = 1 }, func(e *egdm.Entity) { e.Recorded = 2 }, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			expected := testEntity("1", map[string]any{testName: "one"}, nil)
+			result := testEntity("1", map[string]any{testName: "one"}, nil)
+			test.expected(expected)
+			test.result(result)
+			_, diffs := CompareEntities(testCollection(expected), testCollection(result), nil)
+			if summary := diffSummary(diffs); !reflect.DeepEqual(summary, test.diffs) {
+				t.Errorf("expected diffs %v, got %v", test.diffs, summary)
+			}
+		})
+	}
+}
+
+func BenchmarkCompareEntities(b *gotesting.B) {
+	for _, size := range []int{1000, 20000} {
+		expected := generateEntities(size, false)
+		result := generateEntities(size, true)
+		result.Entities[0].SetProperty("http://data.mimiro.io/test/name", "changed")
+
+		b.Run(fmt.Sprint(size), func(b *gotesting.B) {
+			for i := 0; i < b.N; i++ {
+				equal, diffs := CompareEntities(expected, result, nil)
+				if equal || len(diffs) != 1 {
+					b.Fatalf("expected 1 diff, got %d", len(diffs))
+				}
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesDuplicates(t *gotesting.T) {
+	one := func(name string) *egdm.Entity { return testEntity("1", map[string]any{testName: name}, nil) }
+	two := func(name string) *egdm.Entity { return testEntity("2", map[string]any{testName: name}, nil) }
+	tests := []struct {
+		name     string
+		expected *egdm.EntityCollection
+		result   *egdm.EntityCollection
+		diffs    []Diff
+	}{
+		{"duplicate in result, last occurrence equal", testCollection(one("a")), testCollection(one("b"), one("a")),
+			[]Diff{duplicateDiff("http://data.mimiro.io/test/1", 1, 2)}},
+		{"duplicate in expected, last occurrence different", testCollection(one("a"), one("b")), testCollection(one("a")),
+			[]Diff{duplicateDiff("http://data.mimiro.io/test/1", 2, 1), {Type: "diff", EntityId: "http://data.mimiro.io/test/1",
+				Key: testName, ExpectedValue: "b", ResultValue: "a", ValueType: "prop"}}},
+		{"duplicate extra entity", testCollection(one("a")), testCollection(one("a"), two("a"), two("a")),
+			[]Diff{duplicateDiff("http://data.mimiro.io/test/2", 0, 2), {Type: "extra", EntityId: "http://data.mimiro.io/test/2",
+				Key: "http://data.mimiro.io/test/2", ExpectedValue: "N/A", ResultValue: "N/A", ValueType: "entity"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareEntities(test.expected, test.result, nil)
+			if equal || !reflect.DeepEqual(diffs, test.diffs) {
+				t.Errorf("expected diffs %v, got %v", test.diffs, diffs)
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesDiffOrder(t *gotesting.T) {
+	properties := map[string]any{}
+	for _, key := range []string{"e", "c", "a", "d", "b"} {
+		properties["http://data.mimiro.io/test/"+key] = key
+	}
+	expected := testCollection(testEntity("1", properties, map[string]any{"http://data.mimiro.io/test/z": "x"}))
+	result := testCollection(testEntity("1", nil, map[string]any{"http://data.mimiro.io/test/y": "x"}))
+
+	want := []string{"a", "b", "c", "d", "e", "z", "y"}
+	for i := 0; i < 20; i++ {
+		_, diffs := CompareEntities(expected, result, nil)
+		var keys []string
+		for _, diff := range diffs {
+			keys = append(keys, diff.Key[len("http://data.mimiro.io/test/"):])
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("expected diffs for keys %v, got %v", want, keys)
+		}
+	}
+}
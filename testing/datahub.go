@@ -2,52 +2,285 @@ package testing
 
 import (
 	"context"
+	"fmt"
 	dh "github.com/mimiro-io/datahub"
+	"github.com/mimiro-io/datahub-client-sdk-go"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// startMu serializes datahub configuration, as the datahub reads its config from process wide environment variables
+var startMu sync.Mutex
+
+// DefaultReadyTimeout is how long StartTestDatahub waits for the http api of the datahub to answer
+const DefaultReadyTimeout = 30 * time.Second
+
 type DatahubManager struct {
 	Instance *dh.DatahubInstance
 	Location string
+	Port     string
+	Logs     *LogBuffer // messages logged by the jobs of the datahub, nil if started with StartTestDatahub
+	marker   *instanceMarker
+	stopped  chan error // receives the result of Start if the datahub stops
 }
 
+// instanceMarker recognizes a datahub by the message it logs when the marker dataset is created
+type instanceMarker struct {
+	dataset string
+	seen    atomic.Bool
+}
+
+// wrap returns the logger with the messages naming the marker dataset removed and recorded
+func (m *instanceMarker) wrap(logger *zap.SugaredLogger) *zap.SugaredLogger {
+	return logger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &markerCore{Core: core, marker: m}
+	})).Sugar()
+}
+
+// markerCore is a zapcore.Core recording the marker message, which the datahub logs at info level
+type markerCore struct {
+	zapcore.Core
+	marker *instanceMarker
+}
+
+func (c *markerCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.InfoLevel || c.Core.Enabled(level)
+}
+
+func (c *markerCore) With(fields []zapcore.Field) zapcore.Core {
+	return &markerCore{Core: c.Core.With(fields), marker: c.marker}
+}
+
+func (c *markerCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if strings.Contains(entry.Message, c.marker.dataset) {
+		c.marker.seen.Store(true)
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// StartTestDatahub starts a datahub with a temporary store on the given port, or on a free port if port is empty,
+// and waits until its http api answers. Jobs log errors to stderr, see GetLogger.
 func StartTestDatahub(port string) (*DatahubManager, error) {
+	return startTestDatahub(port, GetLogger(), nil)
+}
+
+// StartTestDatahubWithLogLevel starts a datahub like StartTestDatahub, but collects the messages logged by jobs at
+// or above logLevel in Logs instead of writing them to stderr. Messages logged by transforms are collected at all
+// levels. The http api of the datahub logs errors to stderr, regardless of logLevel.
+func StartTestDatahubWithLogLevel(port string, logLevel zapcore.Level) (*DatahubManager, error) {
+	logs := &LogBuffer{}
+	return startTestDatahub(port, logs.Logger(logLevel), logs)
+}
+
+func startTestDatahub(port string, logger *zap.SugaredLogger, logs *LogBuffer) (*DatahubManager, error) {
+	// the port is chosen and bound by the datahub under the lock, so concurrent starts can not pick the same free port
+	startMu.Lock()
+	defer startMu.Unlock()
+
+	if port == "" {
+		freePort, err := GetFreePort()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a free port: %w", err)
+		}
+		port = freePort
+	} else if err := checkPort(port); err != nil {
+		return nil, err
+	}
 
 	tmpDir, err := os.MkdirTemp("", "datahub-jobs-testing-")
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to create temporary store: %w", err)
 	}
 
 	// create store and security folders
 	os.MkdirAll(tmpDir+"/store", 0777)
 	os.MkdirAll(tmpDir+"/security", 0777)
 
+	marker := &instanceMarker{dataset: "djt.instance." + filepath.Base(tmpDir)}
+	dhi, err := newDatahubInstance(tmpDir, port, marker.wrap(logger))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	dm := &DatahubManager{Instance: dhi, Location: tmpDir, Port: port, Logs: logs, marker: marker}
+	// Start blocks until the process is interrupted, it only returns early if the datahub stops while starting
+	dm.stopped = make(chan error, 1)
+	go func() {
+		err := dhi.Start()
+		if err == nil {
+			err = fmt.Errorf("datahub stopped")
+		}
+		dm.stopped <- err
+	}()
+
+	err = dm.WaitUntilReady(DefaultReadyTimeout)
+	if err != nil {
+		dm.Cleanup()
+		return nil, err
+	}
+	return dm, nil
+}
+
+// newDatahubInstance configures a datahub storing its data in dir and listening on port. The logger is used by
+// jobs, while the http api logs errors to stderr. Must be called with startMu held.
+func newDatahubInstance(dir string, port string, logger *zap.SugaredLogger) (*dh.DatahubInstance, error) {
+	// the datahub reads the level of its own logger from the environment when the instance is created
+	previous, isSet := os.LookupEnv("LOG_LEVEL")
 	os.Setenv("LOG_LEVEL", "ERROR")
+	defer func() {
+		if isSet {
+			os.Setenv("LOG_LEVEL", previous)
+		} else {
+			os.Unsetenv("LOG_LEVEL")
+		}
+	}()
 
 	cfg, err := dh.LoadConfig("")
 	if err != nil {
 		return nil, err
 	}
 	cfg.Port = port
-	cfg.StoreLocation = tmpDir + "/store"
-	cfg.SecurityStorageLocation = tmpDir + "/security"
-	cfg.Logger = GetLogger()
+	cfg.StoreLocation = dir + "/store"
+	cfg.SecurityStorageLocation = dir + "/security"
+	cfg.Logger = logger
 
-	dhi, err := dh.NewDatahubInstance(cfg)
-	if err != nil {
-		return nil, err
+	return dh.NewDatahubInstance(cfg)
+}
+
+// URL returns the base url of the datahub's http api
+func (dm *DatahubManager) URL() string {
+	return "http://localhost:" + dm.Port
+}
+
+// WaitUntilReady polls the health endpoint of the datahub until it answers, and returns an error if it does not
+// answer within the timeout or the datahub stops. A datahub started with StartTestDatahub is then asked to create
+// a marker dataset, to make sure the health check did not reach another process listening on the port.
+func (dm *DatahubManager) WaitUntilReady(timeout time.Duration) error {
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(timeout)
+	for {
+		select {
+		case err := <-dm.stopped:
+			return fmt.Errorf("datahub on port %s failed to start: %w", dm.Port, err)
+		default:
+		}
+		response, err := client.Get(dm.URL() + "/health")
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return dm.verifyInstance()
+			}
+			err = fmt.Errorf("health check answered %s", response.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("datahub on port %s not ready after %s: %w", dm.Port, timeout, err)
+		}
+		time.Sleep(50 * time.Millisecond)
 	}
-	go dhi.Start()
+}
 
-	return &DatahubManager{Instance: dhi, Location: tmpDir}, nil
+// verifyInstance creates and deletes the marker dataset of the datahub, and returns an error if the datahub did not
+// log its creation. The health endpoint and the service info are the same for all datahubs.
+func (dm *DatahubManager) verifyInstance() error {
+	if dm.marker == nil {
+		return nil
+	}
+	client, err := datahub.NewClient(dm.URL())
+	if err != nil {
+		return err
+	}
+	err = client.AddDataset(dm.marker.dataset, nil)
+	if err != nil {
+		return fmt.Errorf("port %s is not answered by the started datahub: %w", dm.Port, err)
+	}
+	if !dm.marker.seen.Load() {
+		return fmt.Errorf("port %s is answered by another datahub", dm.Port)
+	}
+	err = client.DeleteDataset(dm.marker.dataset)
+	if err != nil {
+		return fmt.Errorf("failed to delete marker dataset %s: %w", dm.marker.dataset, err)
+	}
+	return nil
 }
 
 func (dm *DatahubManager) Cleanup() {
 	dm.Instance.Stop(context.Background())
 	os.RemoveAll(dm.Location)
-	os.Unsetenv("LOG_LEVEL")
+}
+
+// Reset deletes all jobs and all datasets except the datahub's own core datasets, so the instance can be reused
+// by the next test as if it was fresh
+func (dm *DatahubManager) Reset(client *datahub.Client) error {
+	jobs, err := client.GetJobs()
+	if err != nil {
+		return fmt.Errorf("failed to list jobs: %w", err)
+	}
+	for _, job := range jobs {
+		// the continuation token outlives the job, and would apply to a job with the same id in the next test
+		err = client.ResetJobSinceToken(job.Id, "")
+		if err != nil {
+			return fmt.Errorf("failed to reset continuation token of job %s: %w", job.Id, err)
+		}
+		err = client.DeleteJob(job.Id)
+		if err != nil {
+			return fmt.Errorf("failed to delete job %s: %w", job.Id, err)
+		}
+	}
+	datasets, err := dm.Datasets(client)
+	if err != nil {
+		return err
+	}
+	for _, dataset := range datasets {
+		err = client.DeleteDataset(dataset)
+		if err != nil {
+			return fmt.Errorf("failed to delete dataset %s: %w", dataset, err)
+		}
+	}
+	return nil
+}
+
+// Datasets returns the names of all datasets except the datahub's own core datasets
+func (dm *DatahubManager) Datasets(client *datahub.Client) ([]string, error) {
+	datasets, err := client.GetDatasets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list datasets: %w", err)
+	}
+	var names []string
+	for _, dataset := range datasets {
+		if !strings.HasPrefix(dataset.Name, "core.") {
+			names = append(names, dataset.Name)
+		}
+	}
+	return names, nil
+}
+
+// checkPort returns an error if the port is in use. The datahub only logs a failure to listen on its port, so the
+// port is checked before starting the datahub.
+func checkPort(port string) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("port %s is not available: %w", port, err)
+	}
+	return listener.Close()
+}
+
+// GetFreePort asks the OS for a tcp port that is unused on all interfaces, like the datahub listens, and returns it
+func GetFreePort() (string, error) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return fmt.Sprint(listener.Addr().(*net.TCPAddr).Port), nil
 }
 
 // GetLogger returns it's own *zap.SugaredLogger to override the default logger to minimize datahub log output
@@ -0,0 +1,188 @@
+package testing
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	gotesting "testing"
+	"time"
+
+	"github.com/mimiro-io/datahub-client-sdk-go"
+	"github.com/mimiro-io/datahub-job-testing/jobs"
+	"go.uber.org/zap/zapcore"
+)
+
+// serverPort returns the port of a test server
+func serverPort(server *httptest.Server) string {
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	return port
+}
+
+func TestWaitUntilReady(t *gotesting.T) {
+	// answers all requests like a datahub without logging to the marker's logger
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer foreign.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	stopped := make(chan error, 1)
+	stopped <- errors.New("bind failed")
+
+	tests := []struct {
+		name  string
+		dm    *DatahubManager
+		error string
+	}{
+		{"another datahub on the port", &DatahubManager{Port: serverPort(foreign), marker: &instanceMarker{dataset: "marker"}},
+			"answered by another datahub"},
+		{"health check only", &DatahubManager{Port: serverPort(foreign)}, ""},
+		{"not healthy", &DatahubManager{Port: serverPort(unhealthy)}, "health check answered 503"},
+		{"stopped while starting", &DatahubManager{Port: serverPort(unhealthy), stopped: stopped}, "failed to start: bind failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			start := time.Now()
+			err := test.dm.WaitUntilReady(300 * time.Millisecond)
+			if test.error == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Fatalf("expected an error containing %q, got %v", test.error, err)
+			}
+			if test.dm.stopped != nil && time.Since(start) > 100*time.Millisecond {
+				t.Errorf("expected to fail fast when the datahub stops, took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestStartTestDatahub(t *gotesting.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, used, _ := net.SplitHostPort(listener.Addr().String())
+	if _, err := StartTestDatahub(used); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Fatalf("expected an error for port %s in use, got %v", used, err)
+	}
+
+	dm, err := StartTestDatahub("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Cleanup()
+	if !dm.marker.seen.Load() {
+		t.Errorf("expected the datahub to log the creation of the marker dataset")
+	}
+	client, _ := datahub.NewClient(dm.URL())
+	datasets, err := dm.Datasets(client)
+	if err != nil || len(datasets) != 0 {
+		t.Errorf("expected the marker dataset to be deleted, got %v %v", datasets, err)
+	}
+}
+
+func TestStartTestDatahubWithLogLevel(t *gotesting.T) {
+	t.Setenv("LOG_LEVEL", "DEBUG")
+	tests := []struct {
+		level  zapcore.Level
+		logged bool
+	}{
+		{zapcore.InfoLevel, true},
+		{zapcore.WarnLevel, false},
+	}
+	for _, test := range tests {
+		t.Run(test.level.String(), func(t *gotesting.T) {
+			dm, err := StartTestDatahubWithLogLevel("", test.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dm.Cleanup()
+			if level := os.Getenv("LOG_LEVEL"); level != "DEBUG" {
+				t.Errorf("expected LOG_LEVEL to be restored, got %s", level)
+			}
+			client, _ := datahub.NewClient(dm.URL())
+			if err := client.AddDataset("animals", nil); err != nil {
+				t.Fatal(err)
+			}
+			logged := false
+			for _, entry := range dm.Logs.Take() {
+				if strings.Contains(entry.Message, dm.marker.dataset) {
+					t.Errorf("expected the marker dataset not to be logged, got %s", entry)
+				}
+				logged = logged || entry.Message == "Registering dataset.animals"
+			}
+			if logged != test.logged {
+				t.Errorf("expected the info message of the new dataset to be collected: %v", test.logged)
+			}
+		})
+	}
+
+	dm, err := StartTestDatahub("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Cleanup()
+	if dm.Logs != nil {
+		t.Errorf("expected no log buffer for a datahub logging to stderr")
+	}
+}
+
+func TestReset(t *gotesting.T) {
+	dm, err := StartTestDatahub("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Cleanup()
+	client, _ := datahub.NewClient(dm.URL())
+
+	// copies src to out incrementally, so a continuation token left from a previous test would skip all entities
+	copyJob := &datahub.Job{
+		Id:       "copy",
+		Title:    "copy",
+		Source:   map[string]any{"Type": "DatasetSource", "Name": "src"},
+		Sink:     map[string]any{"Type": "DatasetSink", "Name": "out"},
+		Triggers: []*datahub.JobTrigger{{TriggerType: "cron", JobType: "incremental", Schedule: "@every 2h"}},
+		Paused:   true,
+	}
+	src := testCollection(testEntity("1", map[string]any{testName: "one"}, nil))
+	for i := 0; i < 2; i++ {
+		if err := StoreEntities(&StoredDataset{Name: "src", EntityCollection: src}, client); err != nil {
+			t.Fatal(err)
+		}
+		if err := EnsureDataset("out", client); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.AddJob(copyJob); err != nil {
+			t.Fatal(err)
+		}
+		if err := jobs.RunIncrementalAndWait(client, "copy"); err != nil {
+			t.Fatal(err)
+		}
+		out, err := client.GetEntities("out", "", 0, false, true)
+		if err != nil || len(out.Entities) != 1 {
+			t.Fatalf("run %d: expected the entity to be copied, got %v %v", i+1, out, err)
+		}
+
+		if err := dm.Reset(client); err != nil {
+			t.Fatal(err)
+		}
+		datasets, err := dm.Datasets(client)
+		if err != nil || len(datasets) != 0 {
+			t.Errorf("expected no datasets after reset, got %v %v", datasets, err)
+		}
+		remaining, err := client.GetJobs()
+		if err != nil || len(remaining) != 0 {
+			t.Errorf("expected no jobs after reset, got %v %v", remaining, err)
+		}
+	}
+}
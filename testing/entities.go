@@ -1,10 +1,15 @@
 package testing
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/mimiro-io/datahub-client-sdk-go"
 	egdm "github.com/mimiro-io/entity-graph-data-model"
 	"os"
-	"reflect"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // LoadEntities loads entities from file path and upload to the given datahub dataset
@@ -24,9 +29,35 @@ func LoadEntities(dataset *StoredDataset, client *datahub.Client) error {
 	return nil
 }
 
+// EnsureDataset creates the datahub dataset if it does not exist
+func EnsureDataset(name string, client *datahub.Client) error {
+	datasets, err := client.GetDatasets()
+	if err != nil {
+		return err
+	}
+	for _, d := range datasets {
+		if d.Name == name {
+			return nil
+		}
+	}
+	return client.AddDataset(name, nil)
+}
+
+// StoreEntities stores entities from file path in the given datahub dataset, on top of the entities already
+// in the dataset. The dataset is created if it does not exist.
+func StoreEntities(dataset *StoredDataset, client *datahub.Client) error {
+	err := EnsureDataset(dataset.Name, client)
+	if err != nil {
+		return err
+	}
+	return client.StoreEntities(dataset.Name, dataset.EntityCollection)
+}
+
 // ReadEntities reads entities from file path and returns *egdm.EntityCollection
 func ReadEntities(path string) (*egdm.EntityCollection, error) {
 	nsmanager := egdm.NewNamespaceContext()
+	// matchers in reference values are parsed as prefixed identifiers, so they must expand to themselves
+	nsmanager.StorePrefixExpansionMapping(strings.TrimSuffix(MatcherPrefix, ":"), MatcherPrefix)
 	parser := egdm.NewEntityParser(nsmanager)
 	parser.WithExpandURIs()
 
@@ -42,115 +73,133 @@ func ReadEntities(path string) (*egdm.EntityCollection, error) {
 	return ec, nil
 }
 
-type Diff struct {
-	Type          string // missing, diff, extra
-	Key           string
-	ExpectedValue any
-	ResultValue   any
-	ValueType     string // prop, ref or deleted
+// WriteEntities writes entities to the file path in the same egdm json format as ReadEntities expects.
+// Entities are sorted by id and all URIs are written with namespace prefixes, reusing the prefixes from
+// namespaces where possible. Returns true if the file content changed.
+func WriteEntities(path string, entities []*egdm.Entity, namespaces egdm.NamespaceManager) (bool, error) {
+	prefixer := newPrefixer(namespaces)
+	var compressed []*egdm.Entity
+	for _, entity := range entities {
+		compressed = append(compressed, prefixer.compressEntity(entity))
+	}
+	// stable, so versions of an entity in a changes feed keep their order
+	sort.SliceStable(compressed, func(i, j int) bool {
+		return compressed[i].ID < compressed[j].ID
+	})
+
+	context := egdm.NewContext()
+	context.Namespaces = prefixer.used
+	document := []any{context}
+	for _, entity := range compressed {
+		document = append(document, entity)
+	}
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	content = append(content, '\n')
+
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, content) {
+		return false, nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return false, err
+	}
+	err = os.WriteFile(path, content, 0644)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// CompareEntities compares two EntityCollections and returns true if they are equal
-func CompareEntities(expected *egdm.EntityCollection, result *egdm.EntityCollection) (bool, []Diff) {
-	// strip recorded
-	expected = stripRecorded(expected)
-	result = stripRecorded(result)
-	var diffs []Diff
-	equal := reflect.DeepEqual(expected.Entities, result.Entities)
-	if !equal {
-		for _, expectedEntity := range expected.Entities {
-			found := false
-			for _, resultEntity := range result.Entities {
-				if resultEntity.ID == expectedEntity.ID {
-					found = true
-					if !reflect.DeepEqual(expectedEntity, resultEntity) {
-						if !reflect.DeepEqual(expectedEntity.Properties, resultEntity.Properties) {
-							diffs = append(diffs, findMapDiff(expectedEntity.Properties, resultEntity.Properties, "prop")...)
-						}
-						if !reflect.DeepEqual(expectedEntity.References, resultEntity.References) {
-							diffs = append(diffs, findMapDiff(expectedEntity.References, resultEntity.References, "ref")...)
-						}
-					}
-				}
-			}
-			if !found {
-				diffs = append(diffs, Diff{
-					Type:          "missing",
-					Key:           expectedEntity.ID,
-					ExpectedValue: "N/A",
-					ResultValue:   "N/A",
-					ValueType:     "entity",
-				})
-			}
-		}
-		for _, entity := range result.Entities {
-			found := false
-			for _, entity2 := range expected.Entities {
-				if entity2.ID == entity.ID {
-					found = true
-				}
-			}
-			if !found {
-				diffs = append(diffs, Diff{
-					Type:          "extra",
-					Key:           entity.ID,
-					ExpectedValue: "N/A",
-					ResultValue:   "N/A", // TODO: Optional verbose logging of the extra entity
-					ValueType:     "entity",
-				})
-			}
+// prefixer compresses full URIs to prefixed identifiers and keeps track of the used prefixes
+type prefixer struct {
+	prefixes map[string]string // expansion -> prefix
+	used     map[string]string // prefix -> expansion
+}
+
+func newPrefixer(namespaces egdm.NamespaceManager) *prefixer {
+	p := &prefixer{prefixes: map[string]string{}, used: map[string]string{}}
+	if namespaces != nil {
+		for prefix, expansion := range namespaces.GetNamespaceMappings() {
+			p.prefixes[expansion] = prefix
 		}
 	}
-	return equal, diffs
+	return p
 }
 
-// stripRecorded set recorded timestamp to 0 on all entities
-// in the given EntityCollection to avoid false positives on entity comparison
-func stripRecorded(collection *egdm.EntityCollection) *egdm.EntityCollection {
-	for _, entity := range collection.Entities {
-		entity.Recorded = 0
+func (p *prefixer) compress(uri string) string {
+	i := strings.LastIndexAny(uri, "#/")
+	if i < 0 || i == len(uri)-1 || !strings.Contains(uri, "://") {
+		return uri
+	}
+	expansion := uri[:i+1]
+	prefix, found := p.prefixes[expansion]
+	if !found {
+		taken := map[string]bool{}
+		for _, existing := range p.prefixes {
+			taken[existing] = true
+		}
+		for n := 0; ; n++ {
+			prefix = fmt.Sprintf("ns%d", n)
+			if !taken[prefix] {
+				break
+			}
+		}
+		p.prefixes[expansion] = prefix
 	}
-	return collection
+	p.used[prefix] = expansion
+	return prefix + ":" + uri[i+1:]
 }
 
-// findMapDiff finds the diff between an entity's prop or ref map and returns a []Diff slice
-func findMapDiff(expected, result map[string]any, valueType string) []Diff {
-	var diffs []Diff
-	for key, val := range expected {
-		val2, exist := result[key]
-		if !exist {
-			// Missing in result
-			diffs = append(diffs, Diff{
-				Type:          "missing",
-				Key:           key,
-				ExpectedValue: val,
-				ResultValue:   nil,
-				ValueType:     valueType,
-			})
-		} else if !reflect.DeepEqual(val, val2) {
-			// Different value in result
-			diffs = append(diffs, Diff{
-				Type:          "diff",
-				Key:           key,
-				ExpectedValue: val,
-				ResultValue:   val2,
-				ValueType:     valueType,
-			})
+func (p *prefixer) compressEntity(entity *egdm.Entity) *egdm.Entity {
+	compressed := egdm.NewEntity()
+	compressed.ID = p.compress(entity.ID)
+	compressed.IsDeleted = entity.IsDeleted
+	for key, value := range entity.Properties {
+		compressed.Properties[p.compress(key)] = p.compressValue(value)
+	}
+	for key, value := range entity.References {
+		switch ref := value.(type) {
+		case string:
+			compressed.References[p.compress(key)] = p.compress(ref)
+		case []string:
+			refs := make([]string, 0, len(ref))
+			for _, r := range ref {
+				refs = append(refs, p.compress(r))
+			}
+			compressed.References[p.compress(key)] = refs
+		case []any:
+			refs := make([]any, 0, len(ref))
+			for _, r := range ref {
+				if s, ok := r.(string); ok {
+					refs = append(refs, p.compress(s))
+				} else {
+					refs = append(refs, r)
+				}
+			}
+			compressed.References[p.compress(key)] = refs
+		default:
+			compressed.References[p.compress(key)] = value
 		}
 	}
+	return compressed
+}
 
-	for key, val := range result {
-		_, exist := expected[key]
-		if !exist {
-			// Extra in result
-			diffs = append(diffs, Diff{
-				Type:          "extra",
-				Key:           key,
-				ExpectedValue: "N/A",
-				ResultValue:   val,
-				ValueType:     valueType,
-			})
+// compressValue compresses the keys of sub entities in property values, other values are left as is
+func (p *prefixer) compressValue(value any) any {
+	switch v := value.(type) {
+	case *egdm.Entity:
+		return p.compressEntity(v)
+	case []any:
+		values := make([]any, 0, len(v))
+		for _, item := range v {
+			values = append(values, p.compressValue(item))
 		}
+		return values
+	default:
+		return value
 	}
-	return diffs
 }
@@ -0,0 +1,99 @@
+package testing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	gotesting "testing"
+)
+
+const unsortedEntities = `[
+  {"id": "@context", "namespaces": {"s": "http://data.example.io/src/", "ns0": "http://data.example.io/unused/"}},
+  {"id": "s:2", "props": {"s:name": "two"}, "refs": {"s:type": "s:Thing"}},
+  {"id": "s:1", "props": {"s:name": "first version"}, "refs": {}},
+  {"id": "s:1", "props": {"s:name": "second version"}, "refs": {"s:friends": ["s:2", "http://other.io/people/3"]}}
+]`
+
+func TestWriteEntities(t *gotesting.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.json")
+	if err := os.WriteFile(source, []byte(unsortedEntities), 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadEntities(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(dir, "expected", "snapshot.json")
+	changed, err := WriteEntities(snapshot, read.Entities, read.NamespaceManager)
+	if err != nil || !changed {
+		t.Fatalf("expected a new file to be written, got %v %v", changed, err)
+	}
+	written, _ := os.ReadFile(snapshot)
+	expected := `[
+  {
+    "id": "@context",
+    "namespaces": {
+      "ns1": "http://other.io/people/",
+      "s": "http://data.example.io/src/"
+    }
+  },
+  {
+    "id": "s:1",
+    "refs": {},
+    "props": {
+      "s:name": "first version"
+    }
+  },
+  {
+    "id": "s:1",
+    "refs": {
+      "s:friends": [
+        "s:2",
+        "ns1:3"
+      ]
+    },
+    "props": {
+      "s:name": "second version"
+    }
+  },
+  {
+    "id": "s:2",
+    "refs": {
+      "s:type": "s:Thing"
+    },
+    "props": {
+      "s:name": "two"
+    }
+  }
+]
+`
+	if string(written) != expected {
+		t.Errorf("expected entities sorted by id with prefixes\n%s\ngot\n%s", expected, written)
+	}
+
+	reread, err := ReadEntities(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reread.Entities) != len(read.Entities) {
+		t.Fatalf("expected %d entities after the round trip, got %d", len(read.Entities), len(reread.Entities))
+	}
+	for i, j := range []int{1, 2, 0} {
+		before, after := read.Entities[j], reread.Entities[i]
+		if before.ID != after.ID || !reflect.DeepEqual(before.Properties, after.Properties) ||
+			!reflect.DeepEqual(before.References, after.References) {
+			t.Errorf("expected %v after the round trip, got %v", before, after)
+		}
+	}
+
+	info, _ := os.Stat(snapshot)
+	changed, err = WriteEntities(snapshot, reread.Entities, reread.NamespaceManager)
+	if err != nil || changed {
+		t.Fatalf("expected an unchanged file, got %v %v", changed, err)
+	}
+	if after, _ := os.Stat(snapshot); !after.ModTime().Equal(info.ModTime()) {
+		t.Errorf("expected the unchanged file not to be written")
+	}
+}
@@ -0,0 +1,45 @@
+package testing
+
+import (
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+// EntityComparison holds the expected and result version of an entity with diffs, for rendering entity level diffs.
+// Expected is nil for extra entities and Result is nil for missing entities.
+type EntityComparison struct {
+	Id        string
+	Assertion string // expected output the entity is compared with, for tests with several
+	Expected  *egdm.Entity
+	Result    *egdm.Entity
+}
+
+// CompareEntitiesById returns the expected and result entities for each entity id with diffs, in order of the diffs.
+// Missing and extra entities are only included with their one existing version if verbose is set, since the diff
+// itself identifies them.
+func CompareEntitiesById(expected, result *egdm.EntityCollection, diffs []Diff, verbose bool) []*EntityComparison {
+	expectedIndex, _ := indexEntities(expected.GetEntities())
+	resultIndex, _ := indexEntities(result.GetEntities())
+
+	var comparisons []*EntityComparison
+	seen := map[string]bool{}
+	for _, diff := range diffs {
+		if diff.EntityId == "" || seen[diff.Assertion+" "+diff.EntityId] {
+			continue
+		}
+		seen[diff.Assertion+" "+diff.EntityId] = true
+		comparison := &EntityComparison{
+			Id:        diff.EntityId,
+			Assertion: diff.Assertion,
+			Expected:  expectedIndex[diff.EntityId],
+			Result:    resultIndex[diff.EntityId],
+		}
+		if comparison.Expected == nil && comparison.Result == nil {
+			continue
+		}
+		if (comparison.Expected == nil || comparison.Result == nil) && !verbose {
+			continue
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons
+}
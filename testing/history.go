@@ -0,0 +1,103 @@
+package testing
+
+import (
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+	"sort"
+)
+
+// CompareHistory compares all versions of the entities in a changes feed with the expected versions.
+// Versions are matched by entity id and position, so the nth version of an entity in the feed is compared with
+// its nth version in the expected entities. Entities with a different number of versions are reported as versions diffs.
+func CompareHistory(expected *egdm.EntityCollection, result *egdm.EntityCollection, options *CompareOptions) (bool, []Diff) {
+	c := newComparer(options)
+	expected = stripRecorded(expected)
+	result = stripRecorded(result)
+	var diffs []Diff
+
+	expectedVersions := groupVersions(expected.Entities)
+	resultVersions := groupVersions(result.Entities)
+
+	for _, id := range uniqueIds(expected.Entities) {
+		versions, found := resultVersions[id]
+		if !found {
+			diffs = append(diffs, Diff{
+				Type:          "missing",
+				EntityId:      id,
+				Key:           id,
+				ExpectedValue: "N/A",
+				ResultValue:   "N/A",
+				ValueType:     "entity",
+			})
+			continue
+		}
+		if len(versions) != len(expectedVersions[id]) {
+			diffs = append(diffs, versionsDiff(id, len(expectedVersions[id]), len(versions)))
+		}
+		for i := 0; i < len(versions) && i < len(expectedVersions[id]); i++ {
+			for _, diff := range c.compareEntity(expectedVersions[id][i], versions[i]) {
+				diff.Version = i + 1
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+	for _, id := range uniqueIds(result.Entities) {
+		if _, found := expectedVersions[id]; found {
+			continue
+		}
+		diffs = c.appendExtra(diffs, id, Diff{
+			Type:          "extra",
+			EntityId:      id,
+			Key:           id,
+			ExpectedValue: "N/A",
+			ResultValue:   len(resultVersions[id]),
+			ValueType:     "entity",
+		})
+	}
+	return equalDiffs(diffs), diffs
+}
+
+// CompareVersionCounts compares the number of versions per entity id in a changes feed with the expected counts.
+// Only the entities listed in expected are compared, a count of 0 asserts that the entity is not in the feed.
+func CompareVersionCounts(expected map[string]int, result *egdm.EntityCollection) (bool, []Diff) {
+	counts := map[string]int{}
+	for _, entity := range result.Entities {
+		counts[entity.ID]++
+	}
+	var diffs []Diff
+	for _, id := range sortedIds(expected) {
+		if counts[id] != expected[id] {
+			diffs = append(diffs, versionsDiff(id, expected[id], counts[id]))
+		}
+	}
+	return equalDiffs(diffs), diffs
+}
+
+// groupVersions returns the versions of each entity id in order of occurrence
+func groupVersions(entities []*egdm.Entity) map[string][]*egdm.Entity {
+	versions := map[string][]*egdm.Entity{}
+	for _, entity := range entities {
+		versions[entity.ID] = append(versions[entity.ID], entity)
+	}
+	return versions
+}
+
+func sortedIds(counts map[string]int) []string {
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// versionsDiff reports an entity with an unexpected number of versions, with the number of versions as values
+func versionsDiff(id string, expectedCount, resultCount int) Diff {
+	return Diff{
+		Type:          "versions",
+		EntityId:      id,
+		Key:           id,
+		ExpectedValue: expectedCount,
+		ResultValue:   resultCount,
+		ValueType:     "entity",
+	}
+}
@@ -0,0 +1,95 @@
+package testing
+
+import (
+	"fmt"
+	"reflect"
+	gotesting "testing"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+// versionSummary returns the type, version and key of each diff, like "diff 2 http://data.mimiro.io/test/name"
+func versionSummary(diffs []Diff) []string {
+	var summary []string
+	for _, diff := range diffs {
+		summary = append(summary, fmt.Sprintf("%s %d %s", diff.Type, diff.Version, diff.Key))
+	}
+	return summary
+}
+
+func TestCompareHistory(t *gotesting.T) {
+	const one, two = "http://data.mimiro.io/test/1", "http://data.mimiro.io/test/2"
+	version := func(id string, name string) *egdm.Entity {
+		return testEntity(id, map[string]any{testName: name}, nil)
+	}
+	deleted := func(id string) *egdm.Entity {
+		entity := testEntity(id, nil, nil)
+		entity.IsDeleted = true
+		return entity
+	}
+	tests := []struct {
+		name     string
+		expected *egdm.EntityCollection
+		result   *egdm.EntityCollection
+		options  *CompareOptions
+		diffs    []string
+	}{
+		{"equal", testCollection(version("1", "a"), version("1", "b")), testCollection(version("1", "a"), version("1", "b")), nil, nil},
+		{"interleaved entities", testCollection(version("1", "a"), version("2", "a"), version("1", "b")),
+			testCollection(version("2", "a"), version("1", "a"), version("1", "b")), nil, nil},
+		{"different version", testCollection(version("1", "a"), version("1", "b")), testCollection(version("1", "a"), version("1", "c")), nil,
+			[]string{"diff 2 " + testName}},
+		{"versions in other order", testCollection(version("1", "a"), version("1", "b")), testCollection(version("1", "b"), version("1", "a")), nil,
+			[]string{"diff 1 " + testName, "diff 2 " + testName}},
+		{"deleted version", testCollection(version("1", "a"), deleted("1")), testCollection(version("1", "a"), version("1", "a")), nil,
+			[]string{"extra 2 " + testName, "diff 2 " + one}},
+		{"missing version", testCollection(version("1", "a"), version("1", "b")), testCollection(version("1", "a")), nil,
+			[]string{"versions 0 " + one}},
+		{"extra version", testCollection(version("1", "a")), testCollection(version("1", "a"), version("1", "b")), nil,
+			[]string{"versions 0 " + one}},
+		{"missing entity", testCollection(version("1", "a"), version("2", "a")), testCollection(version("1", "a")), nil,
+			[]string{"missing 0 " + two}},
+		{"extra entity", testCollection(version("1", "a")), testCollection(version("1", "a"), version("2", "a")), nil,
+			[]string{"extra 0 " + two}},
+		{"extra entity in subset mode", testCollection(version("1", "a")), testCollection(version("1", "a"), version("2", "a")),
+			&CompareOptions{Mode: CompareSubset}, nil},
+		{"ignored key", testCollection(version("1", "a"), version("1", "b")), testCollection(version("1", "a"), version("1", "c")),
+			&CompareOptions{Ignore: []*IgnoreRule{{Key: testName}}}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareHistory(test.expected, test.result, test.options)
+			if summary := versionSummary(diffs); !reflect.DeepEqual(summary, test.diffs) {
+				t.Errorf("expected diffs %v, got %v", test.diffs, summary)
+			}
+			if equal != (len(test.diffs) == 0) {
+				t.Errorf("expected equal to be %t", len(test.diffs) == 0)
+			}
+		})
+	}
+}
+
+func TestCompareVersionCounts(t *gotesting.T) {
+	const one, two = "http://data.mimiro.io/test/1", "http://data.mimiro.io/test/2"
+	feed := testCollection(testEntity("1", nil, nil), testEntity("2", nil, nil), testEntity("1", nil, nil))
+	tests := []struct {
+		name     string
+		expected map[string]int
+		diffs    []Diff
+	}{
+		{"matching counts", map[string]int{one: 2, two: 1}, nil},
+		{"only listed entities", map[string]int{one: 2}, nil},
+		{"different count", map[string]int{one: 1, two: 1}, []Diff{versionsDiff(one, 1, 2)}},
+		{"not in feed", map[string]int{"http://data.mimiro.io/test/3": 0}, nil},
+		{"asserted not in feed", map[string]int{two: 0}, []Diff{versionsDiff(two, 0, 1)}},
+		{"sorted by id", map[string]int{two: 2, one: 3}, []Diff{versionsDiff(one, 3, 2), versionsDiff(two, 2, 1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareVersionCounts(test.expected, feed)
+			if !reflect.DeepEqual(diffs, test.diffs) || equal != (len(test.diffs) == 0) {
+				t.Errorf("expected diffs %v, got %v", test.diffs, diffs)
+			}
+		})
+	}
+}
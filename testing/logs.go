@@ -0,0 +1,211 @@
+package testing
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogEntry is a message logged by the datahub while running a test
+type LogEntry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Logger  string         `json:"logger,omitempty"` // name of the datahub component, like scheduler.transform
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+func (e LogEntry) String() string {
+	s := fmt.Sprintf("%s %-5s", e.Time.Format("15:04:05.000"), strings.ToUpper(e.Level))
+	if e.Logger != "" {
+		s += " " + e.Logger
+	}
+	s += " " + e.Message
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s += fmt.Sprintf(" %s=%v", key, e.Fields[key])
+	}
+	return s
+}
+
+// FromTransform returns true for messages logged by job transforms, like Log calls in the transform code
+func (e LogEntry) FromTransform() bool {
+	return isTransformLogger(e.Logger)
+}
+
+func isTransformLogger(name string) bool {
+	return name == "transform" || strings.HasSuffix(name, ".transform")
+}
+
+// LogAssertion expects a number of messages logged by the transform of a test, by level and message
+type LogAssertion struct {
+	Level string `json:"level,omitempty"` // info, warn or error, any level if empty
+	Match string `json:"match,omitempty"` // regular expression the message must match, any message if empty
+	Count *int   `json:"count,omitempty"` // exact number of matching messages, at least one if not set
+}
+
+func (a *LogAssertion) String() string {
+	s := "messages"
+	if a.Level != "" {
+		s = a.level() + " " + s
+	}
+	if a.Match != "" {
+		s += fmt.Sprintf(" matching '%s'", a.Match)
+	}
+	return s
+}
+
+// Validate returns an error if the level or the pattern of the assertion is invalid
+func (a *LogAssertion) Validate() error {
+	switch a.level() {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("unknown log level '%s'", a.Level)
+	}
+	if _, err := regexp.Compile(a.Match); err != nil {
+		return fmt.Errorf("invalid log match '%s': %w", a.Match, err)
+	}
+	if a.Count != nil && *a.Count < 0 {
+		return fmt.Errorf("negative log count %d", *a.Count)
+	}
+	return nil
+}
+
+// level returns the level of the assertion with the aliases accepted by Log in transforms resolved
+func (a *LogAssertion) level() string {
+	switch level := strings.ToLower(a.Level); level {
+	case "warning":
+		return "warn"
+	case "err":
+		return "error"
+	default:
+		return level
+	}
+}
+
+// CompareLogs checks the messages logged by transforms against the assertions, and returns a diff with type logs
+// for each assertion that does not hold. The assertions must be valid.
+func CompareLogs(assertions []*LogAssertion, entries []LogEntry) (bool, []Diff) {
+	var diffs []Diff
+	for _, assertion := range assertions {
+		pattern := regexp.MustCompile(assertion.Match)
+		var matches []string
+		for _, entry := range entries {
+			if (assertion.Level == "" || entry.Level == assertion.level()) && pattern.MatchString(entry.Message) {
+				matches = append(matches, entry.Message)
+			}
+		}
+		var expected any = "at least 1"
+		if assertion.Count != nil {
+			expected = *assertion.Count
+			if len(matches) == *assertion.Count {
+				continue
+			}
+		} else if len(matches) > 0 {
+			continue
+		}
+		diff := Diff{
+			Type:          "logs",
+			Key:           assertion.String(),
+			ExpectedValue: expected,
+			ResultValue:   len(matches),
+			ValueType:     "log",
+		}
+		if len(matches) > 3 {
+			matches = append(matches[:3], "...")
+		}
+		if len(matches) > 0 {
+			diff.Message = strings.Join(matches, "; ")
+		}
+		diffs = append(diffs, diff)
+	}
+	return equalDiffs(diffs), diffs
+}
+
+// LogBuffer collects the messages logged by a datahub in memory
+type LogBuffer struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+// Take returns the collected messages and empties the buffer
+func (b *LogBuffer) Take() []LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := b.entries
+	b.entries = nil
+	return entries
+}
+
+func (b *LogBuffer) add(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, entry)
+}
+
+// Logger returns a logger writing messages at or above the given level to the buffer.
+// Messages from transforms are written at all levels.
+func (b *LogBuffer) Logger(level zapcore.Level) *zap.SugaredLogger {
+	return zap.New(&bufferCore{level: level, buffer: b}).Sugar()
+}
+
+// bufferCore is a zapcore.Core appending log entries to a LogBuffer
+type bufferCore struct {
+	level  zapcore.Level
+	buffer *LogBuffer
+	fields []zapcore.Field // context added with With
+}
+
+// Enabled accepts all levels, as the level only applies to messages not logged by transforms, see Check
+func (c *bufferCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *bufferCore) With(fields []zapcore.Field) zapcore.Core {
+	return &bufferCore{
+		level:  c.level,
+		buffer: c.buffer,
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *bufferCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level >= c.level || isTransformLogger(entry.LoggerName) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *bufferCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(encoder)
+	}
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+	logEntry := LogEntry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Logger:  entry.LoggerName,
+		Message: entry.Message,
+	}
+	if len(encoder.Fields) > 0 {
+		logEntry.Fields = encoder.Fields
+	}
+	c.buffer.add(logEntry)
+	return nil
+}
+
+func (c *bufferCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+package testing
+
+import (
+	"reflect"
+	gotesting "testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogAssertionValidate(t *gotesting.T) {
+	count := func(n int) *int { return &n }
+	tests := []struct {
+		name      string
+		assertion LogAssertion
+		valid     bool
+	}{
+		{"empty", LogAssertion{}, true},
+		{"level and match", LogAssertion{Level: "error", Match: "^failed"}, true},
+		{"level alias", LogAssertion{Level: "Warning"}, true},
+		{"zero count", LogAssertion{Level: "error", Count: count(0)}, true},
+		{"unknown level", LogAssertion{Level: "fatal"}, false},
+		{"invalid match", LogAssertion{Match: "(["}, false},
+		{"negative count", LogAssertion{Count: count(-1)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			if err := test.assertion.Validate(); (err == nil) != test.valid {
+				t.Errorf("expected valid %t, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestCompareLogs(t *gotesting.T) {
+	count := func(n int) *int { return &n }
+	entries := []LogEntry{
+		{Level: "info", Logger: "scheduler.transform", Message: "transformed 1"},
+		{Level: "warn", Logger: "scheduler.transform", Message: "transformed 2"},
+		{Level: "warn", Logger: "scheduler.transform", Message: "transformed 3"},
+		{Level: "error", Logger: "scheduler.transform", Message: "failed 4"},
+	}
+	tests := []struct {
+		name      string
+		assertion LogAssertion
+		diffs     []Diff
+	}{
+		{"any message", LogAssertion{}, nil},
+		{"level", LogAssertion{Level: "error"}, nil},
+		{"level alias", LogAssertion{Level: "warning", Count: count(2)}, nil},
+		{"match", LogAssertion{Match: "^transformed", Count: count(3)}, nil},
+		{"level and match", LogAssertion{Level: "warn", Match: "3$", Count: count(1)}, nil},
+		{"no match", LogAssertion{Level: "info", Match: "failed"}, []Diff{{
+			Type: "logs", Key: "info messages matching 'failed'", ExpectedValue: "at least 1", ResultValue: 0, ValueType: "log"}}},
+		{"no errors", LogAssertion{Level: "err", Count: count(0)}, []Diff{{
+			Type: "logs", Key: "error messages", ExpectedValue: 0, ResultValue: 1, ValueType: "log", Message: "failed 4"}}},
+		{"more than three matches", LogAssertion{Count: count(1)}, []Diff{{
+			Type: "logs", Key: "messages", ExpectedValue: 1, ResultValue: 4, ValueType: "log",
+			Message: "transformed 1; transformed 2; transformed 3; ..."}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			equal, diffs := CompareLogs([]*LogAssertion{&test.assertion}, entries)
+			if !reflect.DeepEqual(diffs, test.diffs) || equal != (len(test.diffs) == 0) {
+				t.Errorf("expected diffs %v, got %v", test.diffs, diffs)
+			}
+		})
+	}
+}
+
+func TestLogBuffer(t *gotesting.T) {
+	buffer := &LogBuffer{}
+	logger := buffer.Logger(zapcore.WarnLevel)
+	logger.Info("dropped")
+	logger.Named("scheduler").Named("transform").Debug("transform message")
+	logger.With("job.jobId", "job1").Warn("kept")
+
+	entries := buffer.Take()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+	if !entries[0].FromTransform() || entries[0].Message != "transform message" || entries[0].Level != "debug" {
+		t.Errorf("expected the transform message at debug level, got %v", entries[0])
+	}
+	if entries[1].FromTransform() || entries[1].Fields["job.jobId"] != "job1" {
+		t.Errorf("expected the warning with its fields, got %v", entries[1])
+	}
+	if entries := buffer.Take(); len(entries) != 0 {
+		t.Errorf("expected an empty buffer after Take, got %v", entries)
+	}
+}
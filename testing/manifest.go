@@ -8,6 +8,7 @@ import (
 	egdm "github.com/mimiro-io/entity-graph-data-model"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type Manifest struct {
@@ -15,22 +16,80 @@ type Manifest struct {
 	Tests         []*Test        `json:"tests"`
 	Variables     map[string]any `json:"variables"`
 	VariablesPath string         `json:"variablesPath"`
+	ProjectRoot   string         `json:"-"`                    // root of the repo containing the manifest, all manifest paths are relative to it
+	Namespaces    Namespaces     `json:"namespaces,omitempty"` // prefixes used to render diffs, in addition to the expected output context
 }
 
 type Test struct {
-	Id                 string                 `json:"id"`
-	Name               string                 `json:"name"`
-	Description        string                 `json:"description"`
-	IncludeCommon      bool                   `json:"includeCommon,omitempty"`
-	Job                *datahub.Job           `json:"-"`
-	JobPath            string                 `json:"jobPath"`
-	RequiredDatasets   []*StoredDataset       `json:"requiredDatasets,omitempty"`
-	ExpectedOutput     *egdm.EntityCollection `json:"-"`
-	ExpectedOutputPath string                 `json:"expectedOutput,omitempty"`
+	Id                 string                            `json:"id"`
+	Name               string                            `json:"name"`
+	Description        string                            `json:"description"`
+	IncludeCommon      bool                              `json:"includeCommon,omitempty"`
+	Job                *datahub.Job                      `json:"-"` // the job of the test, or the final job of a pipeline
+	JobPath            string                            `json:"jobPath"`
+	Jobs               []*datahub.Job                    `json:"-"`                  // jobs of a pipeline in dependency order
+	JobPaths           []string                          `json:"jobPaths,omitempty"` // jobs of a pipeline, run in dependency order instead of a single job
+	RequiredDatasets   []*StoredDataset                  `json:"requiredDatasets,omitempty"`
+	ExpectedOutput     *egdm.EntityCollection            `json:"-"`
+	ExpectedOutputPath string                            `json:"expectedOutput,omitempty"`
+	ExpectedDatasets   map[string]*egdm.EntityCollection `json:"-"`
+	ExpectedOutputs    map[string]string                 `json:"expectedOutputs,omitempty"`   // expected output per dataset, like sinks in a pipeline or datasets written by transforms
+	Isolated           bool                              `json:"isolated,omitempty"`          // always run on a fresh datahub, also when the runner reuses datahubs
+	UnchangedDatasets  []string                          `json:"unchangedDatasets,omitempty"` // required datasets that must not be changed by the run
+	Ignore             []*IgnoreRule                     `json:"ignore,omitempty"`
+	CompareMode        CompareMode                       `json:"compareMode,omitempty"` // exact or subset, defaults to exact
+	UnorderedLists     bool                              `json:"unorderedLists,omitempty"`
+	UnorderedKeys      []string                          `json:"unorderedKeys,omitempty"`
+	Numeric            *NumericComparison                `json:"numeric,omitempty"`
+	ExpectedLogs       []*LogAssertion                   `json:"expectedLogs,omitempty"` // messages the transforms must log, checked after all other assertions
+	Phases             []*TestPhase                      `json:"phases,omitempty"`       // run the job once per phase instead of once, with assertions after each run
+	Steps              []*TestStep                       `json:"steps,omitempty"`        // scenario of uploads, deletes, job runs and assertions instead of a single run
+}
+
+type RunMode string
+
+const (
+	RunFullSync    RunMode = "fullsync"
+	RunIncremental RunMode = "incremental"
+)
+
+// TestPhase is a single job run in a test with phases. The required datasets of the test are loaded before the
+// first phase, and the datasets of each phase are stored on top of the existing datasets before its run.
+type TestPhase struct {
+	Name                string                 `json:"name,omitempty"`
+	Datasets            []*StoredDataset       `json:"datasets,omitempty"` // additional or changed entities stored before the run
+	Run                 RunMode                `json:"run,omitempty"`      // incremental or fullsync, defaults to incremental
+	ExpectedOutput      *egdm.EntityCollection `json:"-"`
+	ExpectedOutputPath  string                 `json:"expectedOutput,omitempty"` // all entities in the sink after the run
+	ExpectedChanges     *egdm.EntityCollection `json:"-"`
+	ExpectedChangesPath string                 `json:"expectedChanges,omitempty"`  // latest version of each entity the run wrote to the sink
+	ExpectedVersions    map[string]int         `json:"expectedVersions,omitempty"` // number of versions per entity id in the sink after the run
+}
+
+// Label returns the name of the phase, or its position in the test if it has no name
+func (p *TestPhase) Label(index int) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("phase %d", index+1)
+}
+
+// RunMode returns the configured run mode of the phase, or incremental if not set.
+// The first incremental run processes all changes in the source, like the job does when it is first deployed.
+func (p *TestPhase) RunMode() RunMode {
+	if p.Run != "" {
+		return p.Run
+	}
+	return RunIncremental
 }
 
 type Common struct {
-	RequiredDatasets []*StoredDataset `json:"requiredDatasets,omitempty"`
+	RequiredDatasets []*StoredDataset   `json:"requiredDatasets,omitempty"`
+	Ignore           []*IgnoreRule      `json:"ignore,omitempty"`
+	UnorderedLists   bool               `json:"unorderedLists,omitempty"`
+	UnorderedKeys    []string           `json:"unorderedKeys,omitempty"`
+	Numeric          *NumericComparison `json:"numeric,omitempty"`
+	ExpectedLogs     []*LogAssertion    `json:"expectedLogs,omitempty"`
 }
 
 type StoredDataset struct {
@@ -43,6 +102,66 @@ func (sd StoredDataset) String() string {
 	return sd.Name
 }
 
+// RequiredDatasets returns the datasets uploaded for the test. If the test includes common, the common datasets
+// are included and replace test datasets with the same name.
+func (m *Manifest) RequiredDatasets(test *Test) []*StoredDataset {
+	if !test.IncludeCommon {
+		return test.RequiredDatasets
+	}
+	var datasets []*StoredDataset
+	for _, dataset := range test.RequiredDatasets {
+		if m.commonDataset(dataset.Name) == nil {
+			datasets = append(datasets, dataset)
+		}
+	}
+	return append(datasets, m.Common.RequiredDatasets...)
+}
+
+// RequiredDataset returns the required dataset of the test with the given name, as uploaded by RequiredDatasets,
+// or nil if the test does not require the dataset
+func (m *Manifest) RequiredDataset(test *Test, name string) *StoredDataset {
+	for _, dataset := range m.RequiredDatasets(test) {
+		if dataset.Name == name {
+			return dataset
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) commonDataset(name string) *StoredDataset {
+	for _, dataset := range m.Common.RequiredDatasets {
+		if dataset.Name == name {
+			return dataset
+		}
+	}
+	return nil
+}
+
+// PipelineJobs returns the jobs of the test in the order they run
+func (t *Test) PipelineJobs() []*datahub.Job {
+	if len(t.Jobs) > 0 {
+		return t.Jobs
+	}
+	return []*datahub.Job{t.Job}
+}
+
+// ExpectedNamespaces returns the namespace prefixes of the expected outputs of the test and its steps
+func (t *Test) ExpectedNamespaces() Namespaces {
+	namespaces := Namespaces{}
+	if t.ExpectedOutput != nil {
+		namespaces = namespaces.Merge(NamespacesFrom(t.ExpectedOutput.GetNamespaceManager()))
+	}
+	for _, expected := range t.ExpectedDatasets {
+		namespaces = namespaces.Merge(NamespacesFrom(expected.GetNamespaceManager()))
+	}
+	for _, step := range t.Scenario() {
+		if step.Expected != nil {
+			namespaces = namespaces.Merge(NamespacesFrom(step.Expected.GetNamespaceManager()))
+		}
+	}
+	return namespaces
+}
+
 func (t *Test) AddRequiredDataset(dataset *StoredDataset) {
 	t.RequiredDatasets = append(t.RequiredDatasets, dataset)
 }
@@ -88,6 +207,7 @@ func (m *Manifest) GetTest(testId string) *Test {
 func LoadManifest(path string) *Manifest {
 	projectRoot := getGitRootPath(filepath.Dir(path))
 	manifest := parseManifestConfig(path)
+	manifest.ProjectRoot = projectRoot
 
 	var variables map[string]any
 	if manifest.VariablesPath != "" {
@@ -104,12 +224,20 @@ func LoadManifest(path string) *Manifest {
 	}
 
 	for i, test := range manifest.Tests {
-		job, err := ReadJobConfig(projectRoot, test.JobPath, variables)
-		if err != nil {
-			log.Printf("failed to read job for test %s: %s", test.Id, err)
-			continue
+		if len(test.JobPaths) > 0 {
+			err := loadPipeline(projectRoot, test, variables)
+			if err != nil {
+				log.Printf("failed to read pipeline for test %s: %s", test.Id, err)
+				continue
+			}
+		} else {
+			job, err := ReadJobConfig(projectRoot, test.JobPath, variables)
+			if err != nil {
+				log.Printf("failed to read job for test %s: %s", test.Id, err)
+				continue
+			}
+			manifest.Tests[i].Job = job
 		}
-		manifest.Tests[i].Job = job
 
 		for y, dataset := range test.RequiredDatasets {
 			ec, err := ReadEntities(filepath.Join(projectRoot, dataset.Path))
@@ -119,6 +247,29 @@ func LoadManifest(path string) *Manifest {
 			manifest.Tests[i].RequiredDatasets[y].EntityCollection = ec
 		}
 
+		for _, phase := range test.Phases {
+			loadPhase(projectRoot, test, phase)
+		}
+		for _, step := range test.Steps {
+			loadStep(projectRoot, test, step)
+		}
+
+		for dataset, path := range test.ExpectedOutputs {
+			expected, err := ReadEntities(filepath.Join(projectRoot, path))
+			if err != nil {
+				log.Printf("failed to read expected output %s for dataset %s in test %s: %s", path, dataset, test.Id, err)
+				continue
+			}
+			if test.ExpectedDatasets == nil {
+				test.ExpectedDatasets = map[string]*egdm.EntityCollection{}
+			}
+			test.ExpectedDatasets[dataset] = expected
+		}
+
+		// tests with phases, steps or expected outputs per dataset may have no expected output for the test
+		if test.ExpectedOutputPath == "" && (len(test.Phases) > 0 || len(test.Steps) > 0 || len(test.ExpectedOutputs) > 0) {
+			continue
+		}
 		expected, err := ReadEntities(filepath.Join(projectRoot, test.ExpectedOutputPath))
 		if err != nil {
 			log.Printf("failed to read expected output for test %s: %s", test.Id, err)
@@ -129,6 +280,95 @@ func LoadManifest(path string) *Manifest {
 	return manifest
 }
 
+// loadPipeline reads the jobs of a pipeline test and orders them by their dependencies
+func loadPipeline(projectRoot string, test *Test, variables map[string]any) error {
+	if test.JobPath != "" {
+		return fmt.Errorf("a test can have either jobPath or jobPaths, not both")
+	}
+	var jobs []*datahub.Job
+	for _, path := range test.JobPaths {
+		job, err := ReadJobConfig(projectRoot, path, variables)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	ordered, err := OrderJobs(jobs)
+	if err != nil {
+		return err
+	}
+	final := FinalJobs(ordered)
+	if len(final) != 1 && usesJobSink(test) {
+		var ids []string
+		for _, job := range final {
+			ids = append(ids, job.Id)
+		}
+		return fmt.Errorf("jobs %s write to sinks no other job reads, name the dataset in expectedOutputs or steps instead of asserting the sink of the pipeline", strings.Join(ids, ", "))
+	}
+	test.Jobs = ordered
+	test.Job = ordered[len(ordered)-1]
+	if len(final) == 1 {
+		test.Job = final[0]
+	}
+	return nil
+}
+
+// usesJobSink returns true if the test asserts or stores entities in the sink of its job without naming the dataset
+func usesJobSink(test *Test) bool {
+	if len(test.Phases) == 0 && len(test.Steps) == 0 {
+		return test.ExpectedOutputPath != "" || len(test.ExpectedOutputs) == 0
+	}
+	for _, step := range test.Scenario() {
+		if step.Type != StepRun && step.Dataset == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadStep reads the entities and expected entities of a test step
+func loadStep(projectRoot string, test *Test, step *TestStep) {
+	if step.Path != "" {
+		ec, err := ReadEntities(filepath.Join(projectRoot, step.Path))
+		if err != nil {
+			log.Printf("failed to read entities %s for test %s: %s", step.Path, test.Id, err)
+		}
+		step.Entities = ec
+	}
+	if step.ExpectedPath != "" {
+		expected, err := ReadEntities(filepath.Join(projectRoot, step.ExpectedPath))
+		if err != nil {
+			log.Printf("failed to read expected entities %s for test %s: %s", step.ExpectedPath, test.Id, err)
+		}
+		step.Expected = expected
+	}
+}
+
+// loadPhase reads the datasets and expected entities of a test phase
+func loadPhase(projectRoot string, test *Test, phase *TestPhase) {
+	for _, dataset := range phase.Datasets {
+		ec, err := ReadEntities(filepath.Join(projectRoot, dataset.Path))
+		if err != nil {
+			log.Printf("failed to read entities from dataset %s in test %s: %s", dataset.Name, test.Id, err)
+		}
+		dataset.EntityCollection = ec
+	}
+	if phase.ExpectedOutputPath != "" {
+		expected, err := ReadEntities(filepath.Join(projectRoot, phase.ExpectedOutputPath))
+		if err != nil {
+			log.Printf("failed to read expected output %s for test %s: %s", phase.ExpectedOutputPath, test.Id, err)
+		}
+		phase.ExpectedOutput = expected
+	}
+	if phase.ExpectedChangesPath != "" {
+		expected, err := ReadEntities(filepath.Join(projectRoot, phase.ExpectedChangesPath))
+		if err != nil {
+			log.Printf("failed to read expected changes %s for test %s: %s", phase.ExpectedChangesPath, test.Id, err)
+		}
+		phase.ExpectedChanges = expected
+	}
+}
+
 // parseManifest parses the manifest file at the given path and returns a *Manifest
 func parseManifestConfig(path string) *Manifest {
 	bytes, err := os.ReadFile(path)
@@ -0,0 +1,45 @@
+package testing
+
+import (
+	gotesting "testing"
+)
+
+func TestRequiredDataset(t *gotesting.T) {
+	testSrc := &StoredDataset{Name: "src", Path: "tests/src.json"}
+	testOther := &StoredDataset{Name: "other", Path: "tests/other.json"}
+	commonSrc := &StoredDataset{Name: "src", Path: "common/src.json"}
+	manifest := &Manifest{Common: Common{RequiredDatasets: []*StoredDataset{commonSrc}}}
+
+	tests := []struct {
+		name          string
+		includeCommon bool
+		dataset       string
+		expected      *StoredDataset
+		uploaded      []*StoredDataset
+	}{
+		{"test dataset", false, "src", testSrc, []*StoredDataset{testSrc, testOther}},
+		{"common replaces test dataset with the same name", true, "src", commonSrc, []*StoredDataset{testOther, commonSrc}},
+		{"test dataset not in common", true, "other", testOther, []*StoredDataset{testOther, commonSrc}},
+		{"not required", true, "missing", nil, []*StoredDataset{testOther, commonSrc}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			manifestTest := &Test{
+				IncludeCommon:    test.includeCommon,
+				RequiredDatasets: []*StoredDataset{testSrc, testOther},
+			}
+			if dataset := manifest.RequiredDataset(manifestTest, test.dataset); dataset != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, dataset)
+			}
+			uploaded := manifest.RequiredDatasets(manifestTest)
+			if len(uploaded) != len(test.uploaded) {
+				t.Fatalf("expected %d uploaded datasets, got %d", len(test.uploaded), len(uploaded))
+			}
+			for i := range uploaded {
+				if uploaded[i] != test.uploaded[i] {
+					t.Errorf("expected %s at %d, got %s", test.uploaded[i].Path, i, uploaded[i].Path)
+				}
+			}
+		})
+	}
+}
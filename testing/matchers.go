@@ -0,0 +1,109 @@
+package testing
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatcherPrefix marks a string value in an expected output file as a matcher instead of an exact value
+const MatcherPrefix = "@match:"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// isMatcher returns true if the expected value is a matcher expression
+func isMatcher(expected any) bool {
+	s, ok := expected.(string)
+	return ok && strings.HasPrefix(s, MatcherPrefix)
+}
+
+// match evaluates the matcher expression against the result value.
+// Returns whether the value matches, and a message explaining why not.
+//
+// Supported matchers:
+//
+//	@match:any            any value
+//	@match:nonEmpty       a non-empty string or list
+//	@match:regex:<expr>   a string, or all strings in a list, matching the regular expression
+//	@match:datetime       an ISO-8601 datetime string
+//	@match:uuid           a UUID string
+//	@match:length:<n>     a list with n elements
+func match(expression string, value any) (bool, string) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(expression, MatcherPrefix), ":")
+	switch name {
+	case "any":
+		return true, ""
+	case "nonEmpty":
+		if list, isList := asList(value); isList {
+			if len(list) == 0 {
+				return false, "expected a non-empty list"
+			}
+			return true, ""
+		}
+		if s, ok := value.(string); !ok || s == "" {
+			return false, fmt.Sprintf("expected a non-empty string, got %v", describe(value))
+		}
+		return true, ""
+	case "regex":
+		pattern, err := regexp.Compile(arg)
+		if err != nil {
+			return false, fmt.Sprintf("invalid regex '%s': %s", arg, err)
+		}
+		values := []any{value}
+		if list, isList := asList(value); isList {
+			values = list
+		}
+		for _, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return false, fmt.Sprintf("expected a string matching %s, got %v", arg, describe(v))
+			}
+			if !pattern.MatchString(s) {
+				return false, fmt.Sprintf("'%s' does not match %s", s, arg)
+			}
+		}
+		return true, ""
+	case "datetime":
+		s, ok := value.(string)
+		if !ok {
+			return false, fmt.Sprintf("expected an ISO-8601 datetime string, got %v", describe(value))
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if _, err := time.Parse(layout, s); err == nil {
+				return true, ""
+			}
+		}
+		return false, fmt.Sprintf("'%s' is not an ISO-8601 datetime", s)
+	case "uuid":
+		s, ok := value.(string)
+		if !ok || !uuidPattern.MatchString(s) {
+			return false, fmt.Sprintf("expected a UUID, got %v", describe(value))
+		}
+		return true, ""
+	case "length":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return false, fmt.Sprintf("invalid length '%s'", arg)
+		}
+		list, isList := asList(value)
+		if !isList {
+			return false, fmt.Sprintf("expected a list of length %d, got %v", n, describe(value))
+		}
+		if len(list) != n {
+			return false, fmt.Sprintf("expected a list of length %d, got length %d", n, len(list))
+		}
+		return true, ""
+	default:
+		return false, fmt.Sprintf("unknown matcher '%s'", name)
+	}
+}
+
+// describe returns the value with its type for matcher messages
+func describe(value any) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("%v (%T)", value, value)
+}
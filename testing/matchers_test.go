@@ -0,0 +1,85 @@
+package testing
+
+import (
+	"reflect"
+	gotesting "testing"
+)
+
+func TestMatch(t *gotesting.T) {
+	tests := []struct {
+		expression string
+		value      any
+		matches    bool
+	}{
+		{"@match:any", nil, true},
+		{"@match:any", 1.0, true},
+		{"@match:nonEmpty", "a", true},
+		{"@match:nonEmpty", "", false},
+		{"@match:nonEmpty", 1.0, false},
+		{"@match:nonEmpty", []any{"a"}, true},
+		{"@match:nonEmpty", []any{}, false},
+		{"@match:regex:^a+$", "aaa", true},
+		{"@match:regex:^a+$", "ab", false},
+		{"@match:regex:^a+$", []any{"a", "aa"}, true},
+		{"@match:regex:^a+$", []any{"a", "b"}, false},
+		{"@match:regex:^a+$", 1.0, false},
+		{"@match:regex:a:b", "a:b", true},
+		{"@match:regex:([", "a", false},
+		{"@match:datetime", "2024-01-02T03:04:05Z", true},
+		{"@match:datetime", "2024-01-02T03:04:05.123+01:00", true},
+		{"@match:datetime", "2024-01-02T03:04:05", true},
+		{"@match:datetime", "2024-01-02", true},
+		{"@match:datetime", "02.01.2024", false},
+		{"@match:datetime", 1.0, false},
+		{"@match:uuid", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"@match:uuid", "123e4567e89b12d3a456426614174000", false},
+		{"@match:uuid", nil, false},
+		{"@match:length:2", []any{"a", "b"}, true},
+		{"@match:length:2", []string{"a", "b"}, true},
+		{"@match:length:2", []any{"a"}, false},
+		{"@match:length:2", "ab", false},
+		{"@match:length:two", []any{"a", "b"}, false},
+		{"@match:unknown", "a", false},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *gotesting.T) {
+			matches, message := match(test.expression, test.value)
+			if matches != test.matches {
+				t.Errorf("expected %t for %v, got %t: %s", test.matches, test.value, matches, message)
+			}
+			if !matches && message == "" {
+				t.Errorf("expected a message explaining the mismatch of %v", test.value)
+			}
+		})
+	}
+}
+
+func TestCompareEntitiesMatchers(t *gotesting.T) {
+	const testId = "http://data.mimiro.io/test/id"
+	expected := testCollection(testEntity("1", map[string]any{
+		testId:   "@match:uuid",
+		testName: "@match:regex:^entity",
+	}, nil))
+	tests := []struct {
+		name   string
+		result map[string]any
+		diffs  []string
+	}{
+		{"matching", map[string]any{testId: "123e4567-e89b-12d3-a456-426614174000", testName: "entity 1"}, nil},
+		{"not matching", map[string]any{testId: "1", testName: "entity 1"}, []string{"diff prop " + testId}},
+		{"missing", map[string]any{testName: "entity 1"}, []string{"missing prop " + testId}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			_, diffs := CompareEntities(expected, testCollection(testEntity("1", test.result, nil)), nil)
+			if summary := diffSummary(diffs); !reflect.DeepEqual(summary, test.diffs) {
+				t.Fatalf("expected diffs %v, got %v", test.diffs, summary)
+			}
+			for _, diff := range diffs {
+				if diff.Type == "diff" && (diff.Rule != "@match:uuid" || diff.Message == "") {
+					t.Errorf("expected the matcher as rule with a message, got rule '%s' and message '%s'", diff.Rule, diff.Message)
+				}
+			}
+		})
+	}
+}
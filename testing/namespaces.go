@@ -0,0 +1,141 @@
+package testing
+
+import (
+	"fmt"
+	"strings"
+
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+// Namespaces maps namespace prefixes to their URI expansions, and is used to render full URIs as prefixed identifiers
+type Namespaces map[string]string
+
+// NamespacesFrom returns the prefixes of the namespace manager, leaving out the default namespace and the matcher prefix
+func NamespacesFrom(namespaces egdm.NamespaceManager) Namespaces {
+	ns := Namespaces{}
+	if namespaces == nil {
+		return ns
+	}
+	for prefix, expansion := range namespaces.GetNamespaceMappings() {
+		if prefix == "_" || expansion == MatcherPrefix {
+			continue
+		}
+		ns[prefix] = expansion
+	}
+	return ns
+}
+
+// Merge returns a copy of the namespaces with the other namespaces added. Prefixes in other take precedence.
+func (ns Namespaces) Merge(other Namespaces) Namespaces {
+	merged := Namespaces{}
+	for prefix, expansion := range ns {
+		merged[prefix] = expansion
+	}
+	for prefix, expansion := range other {
+		merged[prefix] = expansion
+	}
+	return merged
+}
+
+// Prefixed returns the uri as a prefixed identifier using the longest matching expansion,
+// or the uri itself if no prefix applies. Of prefixes sharing an expansion the shortest is used,
+// then the first in name order.
+func (ns Namespaces) Prefixed(uri string) string {
+	bestPrefix, bestExpansion := "", ""
+	for prefix, expansion := range ns {
+		if len(uri) <= len(expansion) || !strings.HasPrefix(uri, expansion) {
+			continue
+		}
+		if len(expansion) > len(bestExpansion) || (expansion == bestExpansion && shorterPrefix(prefix, bestPrefix)) {
+			bestPrefix, bestExpansion = prefix, expansion
+		}
+	}
+	if bestExpansion == "" {
+		return uri
+	}
+	return bestPrefix + ":" + uri[len(bestExpansion):]
+}
+
+// Expand returns the full URI of a prefixed identifier. Full URIs are returned as is.
+func (ns Namespaces) Expand(id string) (string, error) {
+	if strings.Contains(id, "://") {
+		return id, nil
+	}
+	prefix, local, found := strings.Cut(id, ":")
+	if !found {
+		return "", fmt.Errorf("'%s' is neither a full URI nor a prefixed identifier", id)
+	}
+	expansion, found := ns[prefix]
+	if !found {
+		return "", fmt.Errorf("unknown namespace prefix '%s' in '%s'", prefix, id)
+	}
+	return expansion + local, nil
+}
+
+// prefixedValue renders reference values, which are uris or lists of uris, with prefixes
+func (ns Namespaces) prefixedValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return ns.Prefixed(v)
+	case []string:
+		values := make([]string, len(v))
+		for i, s := range v {
+			values[i] = ns.Prefixed(s)
+		}
+		return values
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = ns.prefixedValue(item)
+		}
+		return values
+	default:
+		return value
+	}
+}
+
+// PrefixedEntity returns the entity as a map in the entity graph data model json layout,
+// with the id, keys and references as prefixed identifiers
+func (ns Namespaces) PrefixedEntity(entity *egdm.Entity) map[string]any {
+	e := map[string]any{"id": ns.Prefixed(entity.ID)}
+	if entity.IsDeleted {
+		e["deleted"] = true
+	}
+	if entity.InternalID != 0 {
+		e["internalId"] = entity.InternalID
+	}
+	refs := make(map[string]any, len(entity.References))
+	for key, value := range entity.References {
+		refs[ns.Prefixed(key)] = ns.prefixedValue(value)
+	}
+	e["refs"] = refs
+	props := make(map[string]any, len(entity.Properties))
+	for key, value := range entity.Properties {
+		props[ns.Prefixed(key)] = ns.prefixedProp(value)
+	}
+	e["props"] = props
+	return e
+}
+
+// prefixedProp renders nested entities in property values with prefixes, other values are left as is
+func (ns Namespaces) prefixedProp(value any) any {
+	switch v := value.(type) {
+	case *egdm.Entity:
+		return ns.PrefixedEntity(v)
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = ns.prefixedProp(item)
+		}
+		return values
+	default:
+		return value
+	}
+}
+
+func shorterPrefix(prefix, other string) bool {
+	if len(prefix) != len(other) {
+		return len(prefix) < len(other)
+	}
+	return prefix < other
+}
@@ -0,0 +1,71 @@
+package testing
+
+import (
+	gotesting "testing"
+)
+
+var testNamespaces = Namespaces{
+	"ns0":    "http://data.mimiro.io/",
+	"test":   "http://data.mimiro.io/test/",
+	"t":      "http://data.mimiro.io/test/",
+	"person": "http://data.mimiro.io/test/person/",
+	"other":  "http://example.io/",
+}
+
+func TestNamespacesPrefixed(t *gotesting.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"http://data.mimiro.io/test/person/1", "person:1"},
+		{"http://data.mimiro.io/test/name", "t:name"},
+		{"http://data.mimiro.io/other/1", "ns0:other/1"},
+		{"http://example.io/1", "other:1"},
+		{"http://unknown.io/1", "http://unknown.io/1"},
+		{"http://data.mimiro.io/test/", "ns0:test/"},
+		{"http://data.mimiro.io/", "http://data.mimiro.io/"},
+		{"name", "name"},
+	}
+	for _, test := range tests {
+		t.Run(test.uri, func(t *gotesting.T) {
+			if prefixed := testNamespaces.Prefixed(test.uri); prefixed != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, prefixed)
+			}
+		})
+	}
+}
+
+func TestNamespacesExpand(t *gotesting.T) {
+	tests := []struct {
+		id       string
+		expected string
+		valid    bool
+	}{
+		{"person:1", "http://data.mimiro.io/test/person/1", true},
+		{"t:name", "http://data.mimiro.io/test/name", true},
+		{"test:", "http://data.mimiro.io/test/", true},
+		{"http://data.mimiro.io/test/1", "http://data.mimiro.io/test/1", true},
+		{"unknown:1", "", false},
+		{"name", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.id, func(t *gotesting.T) {
+			expanded, err := testNamespaces.Expand(test.id)
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %t, got error %v", test.valid, err)
+			}
+			if expanded != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, expanded)
+			}
+		})
+	}
+}
+
+func TestNamespacesRoundTrip(t *gotesting.T) {
+	for _, uri := range []string{"http://data.mimiro.io/test/person/1", "http://data.mimiro.io/a/b", "http://example.io/x"} {
+		expanded, err := testNamespaces.Expand(testNamespaces.Prefixed(uri))
+		if err != nil || expanded != uri {
+			t.Errorf("expected %s after prefixing and expanding, got %s (%v)", uri, expanded, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package testing
+
+import (
+	"fmt"
+	"github.com/mimiro-io/datahub-client-sdk-go"
+	"regexp"
+	"strings"
+)
+
+var httpSourcePattern = regexp.MustCompile(`datasets/(.+)/(changes|entities)`)
+
+// LocalSource converts an HttpDatasetSource of the job to a DatasetSource reading the dataset named in the url,
+// so the test runs without external dependencies
+func LocalSource(job *datahub.Job) error {
+	if job.Source["Type"] != "HttpDatasetSource" {
+		return nil
+	}
+	name, err := httpSourceDataset(job)
+	if err != nil {
+		return err
+	}
+	job.Source["Name"] = name
+	job.Source["Type"] = "DatasetSource"
+	return nil
+}
+
+func httpSourceDataset(job *datahub.Job) (string, error) {
+	url, _ := job.Source["Url"].(string)
+	matches := httpSourcePattern.FindStringSubmatch(url)
+	if len(matches) < 2 {
+		return "", fmt.Errorf("failed to parse dataset name from http source url: %s", url)
+	}
+	return matches[1], nil
+}
+
+// SourceDatasets returns the names of the datasets the job reads from
+func SourceDatasets(job *datahub.Job) []string {
+	var names []string
+	switch job.Source["Type"] {
+	case "HttpDatasetSource":
+		if name, err := httpSourceDataset(job); err == nil {
+			names = append(names, name)
+		}
+	case "UnionDatasetSource":
+		datasets, _ := job.Source["DatasetSources"].([]any)
+		for _, dataset := range datasets {
+			if source, ok := dataset.(map[string]any); ok {
+				if name, ok := source["Name"].(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+	case "MultiSource":
+		if name, ok := job.Source["Name"].(string); ok {
+			names = append(names, name)
+		}
+		dependencies, _ := job.Source["Dependencies"].([]any)
+		for _, dependency := range dependencies {
+			d, ok := dependency.(map[string]any)
+			if !ok {
+				continue
+			}
+			if name, ok := d["dataset"].(string); ok {
+				names = append(names, name)
+			}
+			joins, _ := d["joins"].([]any)
+			for _, join := range joins {
+				if j, ok := join.(map[string]any); ok {
+					if name, ok := j["dataset"].(string); ok {
+						names = append(names, name)
+					}
+				}
+			}
+		}
+	default:
+		if name, ok := job.Source["Name"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SinkDataset returns the name of the dataset the job writes to
+func SinkDataset(job *datahub.Job) string {
+	name, _ := job.Sink["Name"].(string)
+	return name
+}
+
+// OrderJobs returns the jobs in dependency order, where a job writing to a dataset runs before the jobs reading
+// from it. Jobs without dependencies between them keep their order. Returns an error if the jobs depend on each
+// other in a cycle.
+func OrderJobs(jobs []*datahub.Job) ([]*datahub.Job, error) {
+	writers := map[string]int{}
+	for i, job := range jobs {
+		if sink := SinkDataset(job); sink != "" {
+			writers[sink] = i
+		}
+	}
+	dependencies := make([]map[int]bool, len(jobs))
+	for i, job := range jobs {
+		dependencies[i] = map[int]bool{}
+		for _, source := range SourceDatasets(job) {
+			if writer, found := writers[source]; found && writer != i {
+				dependencies[i][writer] = true
+			}
+		}
+	}
+
+	var ordered []*datahub.Job
+	done := make([]bool, len(jobs))
+	for len(ordered) < len(jobs) {
+		progress := false
+		for i, job := range jobs {
+			if done[i] || !allDone(dependencies[i], done) {
+				continue
+			}
+			ordered = append(ordered, job)
+			done[i] = true
+			progress = true
+			break
+		}
+		if !progress {
+			var cycle []string
+			for i, job := range jobs {
+				if !done[i] {
+					cycle = append(cycle, job.Id)
+				}
+			}
+			return nil, fmt.Errorf("jobs %s depend on each other in a cycle", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+// FinalJobs returns the jobs whose sink is not read by another job, in the order of jobs
+func FinalJobs(jobs []*datahub.Job) []*datahub.Job {
+	read := map[string]bool{}
+	for _, job := range jobs {
+		sink := SinkDataset(job)
+		for _, source := range SourceDatasets(job) {
+			if source != sink {
+				read[source] = true
+			}
+		}
+	}
+	var final []*datahub.Job
+	for _, job := range jobs {
+		if !read[SinkDataset(job)] {
+			final = append(final, job)
+		}
+	}
+	return final
+}
+
+func allDone(dependencies map[int]bool, done []bool) bool {
+	for dependency := range dependencies {
+		if !done[dependency] {
+			return false
+		}
+	}
+	return true
+}
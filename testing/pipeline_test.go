@@ -0,0 +1,128 @@
+package testing
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	gotesting "testing"
+
+	"github.com/mimiro-io/datahub-client-sdk-go"
+)
+
+// testJob returns a job reading from a DatasetSource and writing to a DatasetSink
+func testJob(id string, source string, sink string) *datahub.Job {
+	return &datahub.Job{
+		Id:     id,
+		Source: map[string]any{"Type": "DatasetSource", "Name": source},
+		Sink:   map[string]any{"Type": "DatasetSink", "Name": sink},
+	}
+}
+
+func TestOrderJobs(t *gotesting.T) {
+	union := &datahub.Job{
+		Id: "union",
+		Source: map[string]any{"Type": "UnionDatasetSource", "DatasetSources": []any{
+			map[string]any{"Type": "DatasetSource", "Name": "b"},
+			map[string]any{"Type": "DatasetSource", "Name": "c"},
+		}},
+		Sink: map[string]any{"Type": "DatasetSink", "Name": "d"},
+	}
+	multi := &datahub.Job{
+		Id: "multi",
+		Source: map[string]any{"Type": "MultiSource", "Name": "src", "Dependencies": []any{
+			map[string]any{"dataset": "other", "joins": []any{map[string]any{"dataset": "b"}}},
+		}},
+		Sink: map[string]any{"Type": "DatasetSink", "Name": "e"},
+	}
+	http := &datahub.Job{
+		Id:     "http",
+		Source: map[string]any{"Type": "HttpDatasetSource", "Url": "http://localhost:8080/datasets/a/changes"},
+		Sink:   map[string]any{"Type": "DatasetSink", "Name": "f"},
+	}
+	tests := []struct {
+		name  string
+		jobs  []*datahub.Job
+		order []string
+		cycle string
+	}{
+		{"chain in order", []*datahub.Job{testJob("1", "src", "a"), testJob("2", "a", "b")}, []string{"1", "2"}, ""},
+		{"chain reversed", []*datahub.Job{testJob("2", "a", "b"), testJob("1", "src", "a")}, []string{"1", "2"}, ""},
+		{"independent jobs keep their order", []*datahub.Job{testJob("2", "x", "y"), testJob("1", "src", "a")}, []string{"2", "1"}, ""},
+		{"external sources", []*datahub.Job{testJob("2", "external", "b"), testJob("1", "src", "a")}, []string{"2", "1"}, ""},
+		{"job reading its own sink", []*datahub.Job{testJob("1", "a", "a")}, []string{"1"}, ""},
+		{"union source", []*datahub.Job{union, testJob("2", "src", "c"), testJob("1", "src", "b")}, []string{"2", "1", "union"}, ""},
+		{"multi source joins", []*datahub.Job{multi, testJob("1", "src", "b")}, []string{"1", "multi"}, ""},
+		{"http source", []*datahub.Job{http, testJob("1", "src", "a")}, []string{"1", "http"}, ""},
+		{"cycle", []*datahub.Job{testJob("1", "a", "b"), testJob("2", "b", "a")}, nil, "1, 2"},
+		{"cycle after independent jobs", []*datahub.Job{testJob("0", "src", "x"), testJob("1", "a", "b"), testJob("2", "b", "a")}, nil, "1, 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			ordered, err := OrderJobs(test.jobs)
+			if test.cycle != "" {
+				if err == nil || !strings.Contains(err.Error(), test.cycle) {
+					t.Fatalf("expected an error for the cycle of %s, got %v", test.cycle, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, job := range ordered {
+				ids = append(ids, job.Id)
+			}
+			if !reflect.DeepEqual(ids, test.order) {
+				t.Errorf("expected order %v, got %v", test.order, ids)
+			}
+		})
+	}
+}
+
+func TestLoadPipeline(t *gotesting.T) {
+	root := t.TempDir()
+	for _, job := range []*datahub.Job{testJob("raw", "src", "a"), testJob("sdb", "a", "b"), testJob("audit", "a", "log")} {
+		data, _ := json.Marshal(job)
+		if err := os.WriteFile(filepath.Join(root, job.Id+".json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name  string
+		test  *Test
+		job   string
+		error string
+	}{
+		{"single final job", &Test{JobPaths: []string{"sdb.json", "raw.json"}, ExpectedOutputPath: "out.json"}, "sdb", ""},
+		{"expected output with two final jobs", &Test{JobPaths: []string{"raw.json", "sdb.json", "audit.json"}, ExpectedOutputPath: "out.json"},
+			"", "jobs sdb, audit write to sinks"},
+		{"no expected output defaults to the sink", &Test{JobPaths: []string{"raw.json", "sdb.json", "audit.json"}}, "", "jobs sdb, audit"},
+		{"expected outputs name the sinks", &Test{JobPaths: []string{"raw.json", "sdb.json", "audit.json"},
+			ExpectedOutputs: map[string]string{"b": "b.json", "log": "log.json"}}, "audit", ""},
+		{"step asserting the sink", &Test{JobPaths: []string{"raw.json", "sdb.json", "audit.json"},
+			Steps: []*TestStep{{Type: StepRun}, {Type: StepAssertDataset, ExpectedPath: "b.json"}}}, "", "jobs sdb, audit"},
+		{"steps naming datasets", &Test{JobPaths: []string{"raw.json", "sdb.json", "audit.json"},
+			Steps: []*TestStep{{Type: StepRun}, {Type: StepAssertDataset, Dataset: "b", ExpectedPath: "b.json"}}}, "audit", ""},
+		{"phase expected output", &Test{JobPaths: []string{"raw.json", "sdb.json", "audit.json"},
+			Phases: []*TestPhase{{ExpectedOutputPath: "b.json"}}}, "", "jobs sdb, audit"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *gotesting.T) {
+			err := loadPipeline(root, test.test, nil)
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Fatalf("expected an error containing %q, got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.test.Job.Id != test.job {
+				t.Errorf("expected final job %s, got %s", test.job, test.test.Job.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package testing
+
+import (
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPassed  Status = "passed"
+	StatusFailed  Status = "failed"  // the job ran, but the output did not match the expected output
+	StatusError   Status = "error"   // the test could not be completed, see Phase and Error
+	StatusSkipped Status = "skipped" // the test was not run
+)
+
+type Phase string
+
+const (
+	PhaseSetup         Phase = "setup"
+	PhaseDatasetUpload Phase = "dataset upload"
+	PhaseJobUpload     Phase = "job upload"
+	PhaseRun           Phase = "run"
+	PhaseCompare       Phase = "compare"
+	PhaseSnapshot      Phase = "snapshot update"
+)
+
+// TestResult holds the outcome of a single manifest test
+type TestResult struct {
+	Id               string              `json:"id"`
+	Name             string              `json:"name"`
+	Description      string              `json:"description,omitempty"`
+	Status           Status              `json:"status"`
+	Phase            Phase               `json:"phase,omitempty"` // phase in which the test failed or errored
+	Duration         time.Duration       `json:"duration"`
+	ExpectedEntities int                 `json:"expectedEntities"` // summed over the expected outputs of the test
+	ResultEntities   int                 `json:"resultEntities"`   // summed over the datasets compared with expected outputs
+	Diffs            []Diff              `json:"diffs,omitempty"`
+	Error            string              `json:"error,omitempty"`
+	JobError         string              `json:"jobError,omitempty"`         // last error reported by the datahub for the job
+	UpdatedSnapshots []string            `json:"updatedSnapshots,omitempty"` // expected output files rewritten in update mode
+	Namespaces       Namespaces          `json:"namespaces,omitempty"`       // prefixes used to render diffs
+	Logs             []LogEntry          `json:"logs,omitempty"`             // messages logged by the datahub while running the test
+	TransformLogs    []LogEntry          `json:"transformLogs,omitempty"`    // messages logged by the transforms of the test
+	Entities         []*EntityComparison `json:"-"`                          // expected and result versions of entities with diffs
+}
+
+func NewTestResult(test *Test) *TestResult {
+	return &TestResult{
+		Id:          test.Id,
+		Name:        test.Name,
+		Description: test.Description,
+		Status:      StatusPassed,
+	}
+}
+
+// SetError marks the result as errored in the given phase
+func (r *TestResult) SetError(phase Phase, err error) {
+	r.Status = StatusError
+	r.Phase = phase
+	r.Error = err.Error()
+}
+
+// SetSkipped marks the result as skipped for the given reason
+func (r *TestResult) SetSkipped(reason string) {
+	r.Status = StatusSkipped
+	r.Error = reason
+}
+
+// AddLogs adds messages logged by the datahub, with the messages from transforms kept apart in TransformLogs
+func (r *TestResult) AddLogs(entries []LogEntry) {
+	for _, entry := range entries {
+		if entry.FromTransform() {
+			r.TransformLogs = append(r.TransformLogs, entry)
+		} else {
+			r.Logs = append(r.Logs, entry)
+		}
+	}
+}
+
+// AddComparison records the outcome of an output comparison and marks a passed result as failed if not equal.
+// Results that already errored keep their status, phase and error.
+// The diffs are attributed to the test and to the assertion, which is empty for tests with a single expected output.
+func (r *TestResult) AddComparison(assertion string, equal bool, diffs []Diff) {
+	for i := range diffs {
+		diffs[i].TestId = r.Id
+		diffs[i].Assertion = assertion
+	}
+	r.Diffs = append(r.Diffs, diffs...)
+	if !equal && r.Status == StatusPassed {
+		r.Status = StatusFailed
+		r.Phase = PhaseCompare
+	}
+}
+
+func (r *TestResult) Passed() bool {
+	return r.Status == StatusPassed
+}
+
+// SuiteResult holds the outcome of all tests in a run, in manifest order
+type SuiteResult struct {
+	Tests       []*TestResult `json:"tests"`
+	Duration    time.Duration `json:"duration"`
+	ReportError error         `json:"-"` // errors of reporters writing the results, like failing to write a report file
+}
+
+// Success returns true if no test failed or errored
+func (s *SuiteResult) Success() bool {
+	for _, t := range s.Tests {
+		if t.Status == StatusFailed || t.Status == StatusError {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of tests with the given status
+func (s *SuiteResult) Count(status Status) int {
+	count := 0
+	for _, t := range s.Tests {
+		if t.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// UpdatedSnapshots returns the expected output files that were rewritten in update mode
+func (s *SuiteResult) UpdatedSnapshots() []string {
+	var paths []string
+	for _, t := range s.Tests {
+		paths = append(paths, t.UpdatedSnapshots...)
+	}
+	return paths
+}
+
+// DiffsByTest returns the diffs of each test in the suite by test id, tests without diffs are left out
+func (s *SuiteResult) DiffsByTest() map[string][]Diff {
+	diffs := map[string][]Diff{}
+	for _, t := range s.Tests {
+		if len(t.Diffs) > 0 {
+			diffs[t.Id] = t.Diffs
+		}
+	}
+	return diffs
+}
+
+// Diffs returns the diffs of all tests in the suite, in test order
+func (s *SuiteResult) Diffs() []Diff {
+	var diffs []Diff
+	for _, t := range s.Tests {
+		diffs = append(diffs, t.Diffs...)
+	}
+	return diffs
+}
@@ -0,0 +1,135 @@
+package testing
+
+import (
+	"fmt"
+	egdm "github.com/mimiro-io/entity-graph-data-model"
+)
+
+type StepType string
+
+const (
+	StepUpload        StepType = "upload"        // store entities in a dataset
+	StepDelete        StepType = "delete"        // store entities in a dataset as deleted
+	StepRun           StepType = "run"           // run the job
+	StepAssertDataset StepType = "assertDataset" // compare all entities in a dataset with the expected entities
+	StepAssertChanges StepType = "assertChanges" // compare the entities changed since the last run with the expected entities
+	StepAssertHistory StepType = "assertHistory" // compare all versions of the entities in the changes feed of a dataset
+)
+
+// TestStep is a single action in a scenario test. Steps run in order against the same datahub instance,
+// after the required datasets of the test are loaded.
+type TestStep struct {
+	Name         string                 `json:"name,omitempty"`
+	Type         StepType               `json:"type"`
+	Dataset      string                 `json:"dataset,omitempty"` // dataset to upload to, delete from or assert, assertions default to the job sink
+	Path         string                 `json:"path,omitempty"`    // entities to upload or delete
+	Entities     *egdm.EntityCollection `json:"-"`
+	Ids          []string               `json:"ids,omitempty"`  // ids of entities to delete, as full URIs or prefixed with the manifest namespaces
+	Mode         RunMode                `json:"mode,omitempty"` // run mode, defaults to incremental
+	Expected     *egdm.EntityCollection `json:"-"`
+	ExpectedPath string                 `json:"expected,omitempty"` // expected entities of an assertion
+	Versions     map[string]int         `json:"versions,omitempty"` // expected number of versions per entity id in a history assertion
+}
+
+// Label returns the name of the step, or its position and type if it has no name
+func (s *TestStep) Label(index int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("step %d (%s)", index+1, s.Type)
+}
+
+// RunMode returns the configured run mode of a run step, or incremental if not set
+func (s *TestStep) RunMode() RunMode {
+	if s.Mode != "" {
+		return s.Mode
+	}
+	return RunIncremental
+}
+
+// DeletedEntities returns the entities of a delete step marked as deleted, from the step's entities and ids.
+// Prefixed ids are expanded with the given namespaces.
+func (s *TestStep) DeletedEntities(namespaces Namespaces) (*egdm.EntityCollection, error) {
+	deleted := egdm.NewEntityCollection(egdm.NewNamespaceContext())
+	if s.Entities != nil {
+		for _, entity := range s.Entities.GetEntities() {
+			e := egdm.NewEntity().SetID(entity.ID)
+			e.IsDeleted = true
+			if err := deleted.AddEntity(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, id := range s.Ids {
+		uri, err := namespaces.Expand(id)
+		if err != nil {
+			return nil, err
+		}
+		e := egdm.NewEntity().SetID(uri)
+		e.IsDeleted = true
+		if err := deleted.AddEntity(e); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+// ExpectedVersions returns the expected number of versions of a history step per full entity id.
+// Prefixed ids are expanded with the given namespaces.
+func (s *TestStep) ExpectedVersions(namespaces Namespaces) (map[string]int, error) {
+	versions := make(map[string]int, len(s.Versions))
+	for id, count := range s.Versions {
+		uri, err := namespaces.Expand(id)
+		if err != nil {
+			return nil, err
+		}
+		versions[uri] = count
+	}
+	return versions, nil
+}
+
+// Scenario returns the steps of the test. Tests with phases are expanded to steps, with one upload step per
+// phase dataset followed by a run and the assertions of the phase.
+func (t *Test) Scenario() []*TestStep {
+	if len(t.Phases) == 0 {
+		return t.Steps
+	}
+	var steps []*TestStep
+	for i, phase := range t.Phases {
+		label := phase.Label(i)
+		for _, dataset := range phase.Datasets {
+			steps = append(steps, &TestStep{
+				Name:     label,
+				Type:     StepUpload,
+				Dataset:  dataset.Name,
+				Path:     dataset.Path,
+				Entities: dataset.EntityCollection,
+			})
+		}
+		steps = append(steps, &TestStep{Name: label, Type: StepRun, Mode: phase.RunMode()})
+		if phase.ExpectedOutputPath != "" {
+			steps = append(steps, &TestStep{
+				Name:         label + " output",
+				Type:         StepAssertDataset,
+				Expected:     phase.ExpectedOutput,
+				ExpectedPath: phase.ExpectedOutputPath,
+			})
+		}
+		if phase.ExpectedChangesPath != "" {
+			steps = append(steps, &TestStep{
+				Name:         label + " changes",
+				Type:         StepAssertChanges,
+				Expected:     phase.ExpectedChanges,
+				ExpectedPath: phase.ExpectedChangesPath,
+			})
+		}
+		if len(phase.ExpectedVersions) > 0 {
+			steps = append(steps, &TestStep{
+				Name:     label + " versions",
+				Type:     StepAssertHistory,
+				Versions: phase.ExpectedVersions,
+			})
+		}
+	}
+	return steps
+}
@@ -51,5 +51,9 @@ func ReadJobConfig(projectRoot string, jobPath string, variables map[string]any)
 			job.Transform.Code = code
 		}
 	}
+	// the client always sends Parallelism, and the datahub runs incremental transforms on no workers if it is 0
+	if job.Transform != nil && job.Transform.Parallelism < 1 {
+		job.Transform.Parallelism = 1
+	}
 	return job, nil
 }